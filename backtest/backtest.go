@@ -0,0 +1,198 @@
+package backtest
+
+import (
+	"errors"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+type Options struct {
+	InitialCash float64
+	Strategy    Strategy
+	// Commission and Fill default to free fills at the order's price
+	Commission CommissionModel
+	Fill       FillModel
+	// BorrowRate is the annual cost of holding short positions
+	BorrowRate float64
+	Exits      ExitRules
+}
+
+type Trade struct {
+	EntryTime int64 `json:"entry_time"`
+	ExitTime  int64 `json:"exit_time"`
+	// Quantity is negative for short positions
+	Quantity   float64 `json:"quantity"`
+	EntryPrice float64 `json:"entry_price"`
+	ExitPrice  float64 `json:"exit_price"`
+	// Costs are the commissions and borrow costs paid, PnL is net of them
+	Costs float64 `json:"costs"`
+	PnL   float64 `json:"pnl"`
+	// Reason is why the position was closed
+	Reason string `json:"reason"`
+}
+
+// EquityPoint is the state of the portfolio at the close of a bar
+type EquityPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Cash      float64 `json:"cash"`
+	Position  float64 `json:"position"`
+	Equity    float64 `json:"equity"`
+}
+
+type Result struct {
+	Trades []Trade       `json:"trades"`
+	Equity []EquityPoint `json:"equity"`
+}
+
+type portfolio struct {
+	cash       float64
+	position   float64
+	entryTime  int64
+	entryPrice float64
+	costs      float64
+	exits      *positionExits
+	trades     []Trade
+	commission CommissionModel
+	fill       FillModel
+}
+
+func (p *portfolio) fillPrice(price float64, side int) float64 {
+	if p.fill == nil {
+		return price
+	}
+	return p.fill.FillPrice(price, side)
+}
+
+func (p *portfolio) charge(quantity, price float64) {
+	if p.commission == nil {
+		return
+	}
+	commission := p.commission.Commission(quantity, price)
+	p.cash -= commission
+	p.costs += commission
+}
+
+// open enters a position of quantity, which is negative for shorts
+func (p *portfolio) open(quantity, price float64, timestamp int64) {
+	side := model.Buy
+	if quantity < 0 {
+		side = model.Sell
+	}
+	price = p.fillPrice(price, side)
+	p.cash -= quantity * price
+	p.position = quantity
+	p.entryTime = timestamp
+	p.entryPrice = price
+	p.costs = 0
+	p.charge(quantity, price)
+}
+
+func (p *portfolio) close(price float64, timestamp int64, reason string) {
+	side := model.Sell
+	if p.position < 0 {
+		side = model.Buy
+	}
+	price = p.fillPrice(price, side)
+	p.cash += p.position * price
+	p.charge(p.position, price)
+	p.trades = append(p.trades, Trade{
+		EntryTime:  p.entryTime,
+		ExitTime:   timestamp,
+		Quantity:   p.position,
+		EntryPrice: p.entryPrice,
+		ExitPrice:  price,
+		Costs:      p.costs,
+		PnL:        p.position*(price-p.entryPrice) - p.costs,
+		Reason:     reason,
+	})
+	p.position = 0
+	p.exits = nil
+}
+
+// borrow charges for holding a short position over duration
+func (p *portfolio) borrow(price, rate float64, duration int64) {
+	if p.position >= 0 {
+		return
+	}
+	cost := borrowCost(p.position*price, rate, duration)
+	p.cash -= cost
+	p.costs += cost
+}
+
+func (p *portfolio) equity(price float64) float64 {
+	return p.cash + p.position*price
+}
+
+// Run walks data bar by bar, asking the strategy for orders at the close
+// of each bar with only the data up to and including it. Orders are
+// filled during the next bar, after which the exit rules are checked
+// against the rest of it. Positions entered after a bar's open are only
+// checked from the next bar, as it can't be told which of the bar's prices
+// came after the fill. Any open position is closed at the final bar's
+// close.
+func Run(m model.Model, data []record.Market, opts Options) (*Result, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no data to backtest")
+	}
+	p := &portfolio{
+		cash:       opts.InitialCash,
+		commission: opts.Commission,
+		fill:       opts.Fill,
+	}
+	result := &Result{
+		Equity: make([]EquityPoint, 0, len(data)),
+	}
+
+	var orders []Order
+	for t, bar := range data {
+		if t > 0 {
+			p.borrow(data[t-1].Close, opts.BorrowRate, bar.Timestamp-data[t-1].Timestamp)
+		}
+		enteredIntraBar := false
+		for _, order := range orders {
+			equity := p.equity(bar.Open)
+			buying := order.Target*equity > p.position*bar.Open
+			price, filled := order.fill(bar, buying)
+			if !filled {
+				continue
+			}
+			if p.position != 0 {
+				p.close(price, bar.Timestamp, SignalExit)
+			}
+			if order.Target != 0 {
+				p.open(order.Target*p.cash/price, price, bar.Timestamp)
+				p.exits = opts.Exits.arm(data[:t], p.entryPrice, order.Target > 0, t)
+				enteredIntraBar = price != bar.Open
+			}
+			break
+		}
+		orders = nil
+		if p.exits != nil && !enteredIntraBar {
+			if price, reason, closed := p.exits.check(opts.Exits, bar, t); closed {
+				p.close(price, bar.Timestamp, reason)
+			}
+		}
+
+		if t == len(data)-1 {
+			if p.position != 0 {
+				p.close(bar.Close, bar.Timestamp, EndExit)
+			}
+		} else {
+			var err error
+			orders, err = opts.Strategy.Decide(data[:t+1], m, p.position)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result.Equity = append(result.Equity, EquityPoint{
+			Timestamp: bar.Timestamp,
+			Cash:      p.cash,
+			Position:  p.position,
+			Equity:    p.equity(bar.Close),
+		})
+	}
+	result.Trades = p.trades
+	return result, nil
+}
@@ -0,0 +1,134 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// fixedModel returns its items as neighbours of any observation, in the
+// order they are held
+type fixedModel struct {
+	model.Model
+	items []model.Item
+}
+
+func (m *fixedModel) Options() splicer.SpliceOptions {
+	return splicer.SpliceOptions{Period: 2, ResultN: 1}
+}
+
+func (m *fixedModel) Neighbours(_ record.Model, k int) ([]*model.Neighbour, error) {
+	var neighbours []*model.Neighbour
+	for i, item := range m.items {
+		if i == k {
+			break
+		}
+		neighbours = append(neighbours, &model.Neighbour{Distance: float64(i), Index: i, Item: item})
+	}
+	return neighbours, nil
+}
+
+func TestAvailableNeighbours(t *testing.T) {
+	m := &fixedModel{items: []model.Item{
+		{ResultTime: 50},
+		{ResultTime: 40},
+		{ResultTime: 10},
+		{ResultTime: 30},
+		{ResultTime: 20},
+	}}
+	neighbours, err := availableNeighbours(m, record.Model{}, 2, 30)
+	assert.NoError(t, err)
+	assert.Len(t, neighbours, 2)
+	assert.Equal(t, 2, neighbours[0].Index)
+	assert.Equal(t, 3, neighbours[1].Index)
+
+	neighbours, err = availableNeighbours(m, record.Model{}, 4, 30)
+	assert.NoError(t, err)
+	assert.Len(t, neighbours, 3)
+	for _, n := range neighbours {
+		assert.LessOrEqual(t, n.Item.ResultTime, int64(30))
+	}
+}
+
+func TestRun(t *testing.T) {
+	data := make([]record.Market, 8)
+	for i := range data {
+		price := float64(100 + i)
+		data[i] = record.Market{
+			Timestamp: int64(i * 10),
+			Open:      price,
+			High:      price + 1,
+			Low:       price - 1,
+			Close:     price + 0.5,
+		}
+	}
+	// the nearest item says sell, but its result isn't known until after
+	// the last bar, so only the buy is traded on once it is known
+	m := &fixedModel{items: []model.Item{
+		{Result: -1, ResultTime: 1000},
+		{Result: 1, ResultTime: 30},
+	}}
+	result, err := Run(m, data, Options{
+		InitialCash: 1000,
+		Strategy: KNNVote{
+			Prediction: model.PredictionOpts{NearestN: 1},
+			Sizer:      FixedFraction{Fraction: 1},
+		},
+	})
+	assert.NoError(t, err)
+
+	// decided at the close of the bar at 30, filled at the next open
+	assert.Len(t, result.Trades, 1)
+	trade := result.Trades[0]
+	assert.Equal(t, int64(40), trade.EntryTime)
+	assert.Equal(t, float64(104), trade.EntryPrice)
+	assert.Equal(t, int64(70), trade.ExitTime)
+	assert.Equal(t, 107.5, trade.ExitPrice)
+	assert.Equal(t, EndExit, trade.Reason)
+	assert.InDelta(t, 1000/104.0*3.5, trade.PnL, 1e-9)
+
+	assert.Len(t, result.Equity, len(data))
+	final := result.Equity[len(result.Equity)-1]
+	assert.Equal(t, float64(0), final.Position)
+	assert.InDelta(t, 1000+trade.PnL, final.Equity, 1e-9)
+}
+
+// scriptedStrategy places the orders listed for the index of the bar
+// being decided at
+type scriptedStrategy map[int][]Order
+
+func (s scriptedStrategy) Decide(history []record.Market, _ model.Model, _ float64) ([]Order, error) {
+	return s[len(history)-1], nil
+}
+
+func TestRunIntraBarEntry(t *testing.T) {
+	data := []record.Market{
+		{Timestamp: 0, Open: 100, High: 101, Low: 99, Close: 100},
+		// the buy stop fills at 103 on the way up, after the bar opened
+		// below where the stop loss ends up
+		{Timestamp: 10, Open: 100, High: 105, Low: 99, Close: 104},
+		{Timestamp: 20, Open: 104, High: 105, Low: 103, Close: 104.5},
+		{Timestamp: 30, Open: 104, High: 104, Low: 100, Close: 101},
+	}
+	result, err := Run(nil, data, Options{
+		InitialCash: 1000,
+		Strategy: scriptedStrategy{
+			0: {{Type: StopOrder, Target: 1, Price: 103}},
+		},
+		Exits: ExitRules{StopLoss: 0.02},
+	})
+	assert.NoError(t, err)
+
+	// the stop loss is only checked from the bar after the entry
+	assert.Len(t, result.Trades, 1)
+	trade := result.Trades[0]
+	assert.Equal(t, int64(10), trade.EntryTime)
+	assert.Equal(t, float64(103), trade.EntryPrice)
+	assert.Equal(t, int64(30), trade.ExitTime)
+	assert.InDelta(t, 103*0.98, trade.ExitPrice, 1e-9)
+	assert.Equal(t, StopLossExit, trade.Reason)
+}
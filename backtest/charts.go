@@ -0,0 +1,72 @@
+package backtest
+
+import (
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+func timeAxis(equity []EquityPoint) []string {
+	axis := make([]string, len(equity))
+	for i, point := range equity {
+		axis[i] = time.UnixMilli(point.Timestamp).UTC().Format("2006-01-02 15:04")
+	}
+	return axis
+}
+
+func lineChart(title string, axis []string) *charts.Line {
+	line := charts.NewLine()
+	line.SetXAxis(axis)
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: title,
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{
+			Type: "slider",
+		}),
+	)
+	return line
+}
+
+// EquityChart plots the equity of a backtest against a benchmark run over
+// the same data
+func EquityChart(result, benchmark *Result) *charts.Line {
+	line := lineChart("Equity", timeAxis(result.Equity))
+	for _, series := range []struct {
+		name   string
+		result *Result
+	}{{"Strategy", result}, {"Buy and hold", benchmark}} {
+		data := make([]opts.LineData, len(series.result.Equity))
+		for i, point := range series.result.Equity {
+			data[i] = opts.LineData{Value: point.Equity}
+		}
+		line.AddSeries(series.name, data)
+	}
+	return line
+}
+
+// DrawdownChart plots how far below its peak the equity of a backtest and
+// a benchmark run over the same data are
+func DrawdownChart(result, benchmark *Result) *charts.Line {
+	line := lineChart("Drawdown", timeAxis(result.Equity))
+	for _, series := range []struct {
+		name   string
+		result *Result
+	}{{"Strategy", result}, {"Buy and hold", benchmark}} {
+		drawdowns := Drawdowns(series.result.Equity)
+		data := make([]opts.LineData, len(drawdowns))
+		for i, dd := range drawdowns {
+			data[i] = opts.LineData{Value: -dd}
+		}
+		line.AddSeries(series.name, data)
+	}
+	return line
+}
@@ -0,0 +1,55 @@
+package backtest
+
+import (
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+const yearMillis = float64(365 * 24 * 60 * 60 * 1000)
+
+// CommissionModel is what the broker charges for a fill
+type CommissionModel interface {
+	Commission(quantity, price float64) float64
+}
+
+// FillModel adjusts the price an order is filled at, side is model.Buy or
+// model.Sell
+type FillModel interface {
+	FillPrice(price float64, side int) float64
+}
+
+// FixedCommission charges PerTrade on every fill, plus Rate of its value
+type FixedCommission struct {
+	PerTrade float64
+	Rate     float64
+}
+
+func (c FixedCommission) Commission(quantity, price float64) float64 {
+	if quantity < 0 {
+		quantity = -quantity
+	}
+	return c.PerTrade + c.Rate*quantity*price
+}
+
+// BpsFill crosses half of SpreadBps and pays SlippageBps on every fill,
+// both in basis points of the price
+type BpsFill struct {
+	SpreadBps   float64
+	SlippageBps float64
+}
+
+func (f BpsFill) FillPrice(price float64, side int) float64 {
+	adjustment := (f.SpreadBps/2 + f.SlippageBps) / 10000
+	if side == model.Sell {
+		return price * (1 - adjustment)
+	}
+	return price * (1 + adjustment)
+}
+
+// borrowCost is the cost of holding a short position worth value for
+// duration milliseconds at an annual rate
+func borrowCost(value, rate float64, duration int64) float64 {
+	if value < 0 {
+		value = -value
+	}
+	return value * rate * float64(duration) / yearMillis
+}
@@ -0,0 +1,62 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+func TestPortfolioCosts(t *testing.T) {
+	p := &portfolio{
+		cash:       1000,
+		commission: FixedCommission{PerTrade: 1, Rate: 0.001},
+		fill:       BpsFill{SpreadBps: 10, SlippageBps: 5},
+	}
+
+	// shorting 10 at 100 fills 10bps lower, then buying back at 90 fills
+	// 10bps higher
+	p.open(-10, 100, 0)
+	assert.InDelta(t, 99.9, p.entryPrice, 1e-9)
+	p.borrow(100, 0.1, int64(yearMillis))
+	p.close(90, 1, SignalExit)
+
+	trade := p.trades[0]
+	assert.InDelta(t, 90.09, trade.ExitPrice, 1e-9)
+	commissions := 2 + 0.001*10*(99.9+90.09)
+	assert.InDelta(t, commissions+100, trade.Costs, 1e-9)
+	assert.InDelta(t, 10*(99.9-90.09)-trade.Costs, trade.PnL, 1e-9)
+	assert.InDelta(t, 1000+trade.PnL, p.cash, 1e-9)
+}
+
+func TestSizers(t *testing.T) {
+	confident := &model.Prediction{Confidence: 0.8}
+	unsure := &model.Prediction{Confidence: 0.4}
+	kelly := Kelly{Scale: 0.5, MaxFraction: 1}
+	assert.InDelta(t, 0.3, kelly.Size(nil, confident), 1e-9)
+	assert.Equal(t, float64(0), kelly.Size(nil, unsure))
+
+	// alternating daily returns of +-1% have a daily standard deviation
+	// just over 1%
+	history := make([]record.Market, 101)
+	price := float64(100)
+	for i := range history {
+		if i%2 == 0 {
+			price *= 1.01
+		} else {
+			price /= 1.01
+		}
+		history[i] = record.Market{Timestamp: int64(i) * 24 * 60 * 60 * 1000, Close: price}
+	}
+	dailyStd := math.Log(1.01) * math.Sqrt(100.0/99.0)
+	vol := annualisedVolatility(history, 100)
+	assert.InDelta(t, dailyStd*math.Sqrt(365), vol, 1e-9)
+
+	sizer := VolatilityTarget{Target: vol / 2, Lookback: 100, MaxFraction: 1}
+	assert.InDelta(t, 0.5, sizer.Size(history, nil), 1e-9)
+	sizer.MaxFraction = 0.25
+	assert.Equal(t, 0.25, sizer.Size(history, nil))
+}
@@ -0,0 +1,147 @@
+package backtest
+
+import (
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+// reasons a position was closed
+const (
+	SignalExit       = "signal"
+	StopLossExit     = "stop_loss"
+	TakeProfitExit   = "take_profit"
+	TrailingStopExit = "trailing_stop"
+	MaxHoldingExit   = "max_holding"
+	EndExit          = "end"
+)
+
+// ExitRules close positions independently of the strategy. Prices are
+// fractions away from the entry price, a zero rule is not applied.
+type ExitRules struct {
+	StopLoss float64
+	// ATRStop places the stop loss this many average true ranges, measured
+	// over ATRPeriod bars before entry, away from the entry price instead
+	ATRStop   float64
+	ATRPeriod int
+	// TakeProfit closes positions once they are this far in profit
+	TakeProfit float64
+	// TrailingStop closes positions once they fall this far from the best
+	// price reached since entry
+	TrailingStop float64
+	// MaxHolding is how many bars after entry positions are closed at the
+	// open of
+	MaxHolding int
+}
+
+// HorizonHolding is the MaxHolding that closes positions once the result
+// they were entered on is known. Results end at the open horizon bars
+// after a prediction and entries fill the bar after it, but positions are
+// held for at least a bar as 0 would hold them indefinitely.
+func HorizonHolding(horizon int) int {
+	if horizon < 2 {
+		return 1
+	}
+	return horizon - 1
+}
+
+// positionExits are the exit levels of an open position
+type positionExits struct {
+	long       bool
+	entryIndex int
+	stop       float64
+	takeProfit float64
+	best       float64
+}
+
+func (rules ExitRules) arm(history []record.Market, price float64, long bool, index int) *positionExits {
+	direction := float64(1)
+	if !long {
+		direction = -1
+	}
+	exits := &positionExits{
+		long:       long,
+		entryIndex: index,
+		best:       price,
+	}
+	if rules.ATRStop > 0 {
+		if atr := averageTrueRange(history, rules.ATRPeriod); atr > 0 {
+			exits.stop = price - direction*rules.ATRStop*atr
+		}
+	} else if rules.StopLoss > 0 {
+		exits.stop = price * (1 - direction*rules.StopLoss)
+	}
+	if rules.TakeProfit > 0 {
+		exits.takeProfit = price * (1 + direction*rules.TakeProfit)
+	}
+	return exits
+}
+
+// check returns the price and reason the position is closed at within
+// bar, if it is. When a bar reaches both a stop and the take profit it
+// can't be told which came first, so the stop is assumed.
+func (exits *positionExits) check(rules ExitRules, bar record.Market, index int) (float64, string, bool) {
+	if rules.MaxHolding > 0 && index-exits.entryIndex >= rules.MaxHolding {
+		return bar.Open, MaxHoldingExit, true
+	}
+
+	stop, stopReason := exits.stop, StopLossExit
+	if rules.TrailingStop > 0 {
+		var trailing float64
+		if exits.long {
+			trailing = exits.best * (1 - rules.TrailingStop)
+		} else {
+			trailing = exits.best * (1 + rules.TrailingStop)
+		}
+		if stop == 0 || (exits.long && trailing > stop) || (!exits.long && trailing < stop) {
+			stop, stopReason = trailing, TrailingStopExit
+		}
+	}
+
+	// flip shorts so the checks below only have to consider longs
+	open, high, low := bar.Open, bar.High, bar.Low
+	takeProfit := exits.takeProfit
+	if !exits.long {
+		open, high, low = -open, -low, -high
+		stop, takeProfit = -stop, -takeProfit
+	}
+	hasStop, hasTakeProfit := exits.stop != 0 || rules.TrailingStop > 0, exits.takeProfit != 0
+	price, reason, closed := float64(0), "", false
+	switch {
+	case hasStop && open <= stop:
+		price, reason, closed = open, stopReason, true
+	case hasTakeProfit && open >= takeProfit:
+		price, reason, closed = open, TakeProfitExit, true
+	case hasStop && low <= stop:
+		price, reason, closed = stop, stopReason, true
+	case hasTakeProfit && high >= takeProfit:
+		price, reason, closed = takeProfit, TakeProfitExit, true
+	}
+	if !exits.long {
+		price = -price
+	}
+
+	if exits.long {
+		exits.best = math.Max(exits.best, bar.High)
+	} else {
+		exits.best = math.Min(exits.best, bar.Low)
+	}
+	return price, reason, closed
+}
+
+// averageTrueRange is the mean true range of the last period bars
+func averageTrueRange(history []record.Market, period int) float64 {
+	if period > len(history)-1 {
+		period = len(history) - 1
+	}
+	if period < 1 {
+		return 0
+	}
+	total := float64(0)
+	for i := len(history) - period; i < len(history); i++ {
+		prevClose := history[i-1].Close
+		trueRange := math.Max(history[i].High, prevClose) - math.Min(history[i].Low, prevClose)
+		total += trueRange
+	}
+	return total / float64(period)
+}
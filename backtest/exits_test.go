@@ -0,0 +1,83 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+func TestExits(t *testing.T) {
+	bar := func(open, high, low float64) record.Market {
+		return record.Market{Open: open, High: high, Low: low, Close: open}
+	}
+	tests := []struct {
+		name   string
+		rules  ExitRules
+		long   bool
+		bars   []record.Market
+		price  float64
+		reason string
+	}{
+		{"long stop", ExitRules{StopLoss: 0.05, TakeProfit: 0.1}, true,
+			[]record.Market{bar(100, 102, 96), bar(97, 98, 94)}, 95, StopLossExit},
+		{"long take profit", ExitRules{StopLoss: 0.05, TakeProfit: 0.1}, true,
+			[]record.Market{bar(100, 111, 99)}, 110, TakeProfitExit},
+		{"both in one bar assumes the stop", ExitRules{StopLoss: 0.05, TakeProfit: 0.1}, true,
+			[]record.Market{bar(100, 111, 94)}, 95, StopLossExit},
+		{"gap through the stop fills at the open", ExitRules{StopLoss: 0.05}, true,
+			[]record.Market{bar(100, 101, 99), bar(90, 92, 88)}, 90, StopLossExit},
+		{"short stop", ExitRules{StopLoss: 0.05, TakeProfit: 0.1}, false,
+			[]record.Market{bar(100, 106, 99)}, 105, StopLossExit},
+		{"short take profit", ExitRules{StopLoss: 0.05, TakeProfit: 0.1}, false,
+			[]record.Market{bar(100, 101, 89)}, 90, TakeProfitExit},
+		{"trailing stop follows the high", ExitRules{TrailingStop: 0.1}, true,
+			[]record.Market{bar(100, 120, 100), bar(118, 119, 107)}, 108, TrailingStopExit},
+		{"trailing stop replaces a looser stop", ExitRules{StopLoss: 0.2, TrailingStop: 0.1}, true,
+			[]record.Market{bar(100, 101, 99), bar(100, 100, 85)}, 90.9, TrailingStopExit},
+		{"max holding closes at the open", ExitRules{MaxHolding: 2}, true,
+			[]record.Market{bar(100, 101, 99), bar(100, 101, 99), bar(103, 104, 102)}, 103, MaxHoldingExit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exits := test.rules.arm(nil, 100, test.long, 0)
+			for i, b := range test.bars {
+				price, reason, closed := exits.check(test.rules, b, i)
+				if i < len(test.bars)-1 {
+					assert.False(t, closed)
+					continue
+				}
+				assert.True(t, closed)
+				assert.InDelta(t, test.price, price, 1e-9)
+				assert.Equal(t, test.reason, reason)
+			}
+		})
+	}
+
+	t.Run("horizon holding", func(t *testing.T) {
+		assert.Equal(t, 23, HorizonHolding(24))
+		// a result 1 bar ahead is known by the open the position entered at,
+		// so it is held as briefly as it can be rather than indefinitely
+		rules := ExitRules{MaxHolding: HorizonHolding(1)}
+		exits := rules.arm(nil, 100, true, 0)
+		_, _, closed := exits.check(rules, bar(100, 101, 99), 0)
+		assert.False(t, closed)
+		price, reason, closed := exits.check(rules, bar(102, 103, 101), 1)
+		assert.True(t, closed)
+		assert.Equal(t, float64(102), price)
+		assert.Equal(t, MaxHoldingExit, reason)
+	})
+
+	t.Run("atr stop", func(t *testing.T) {
+		history := []record.Market{
+			{High: 101, Low: 99, Close: 100},
+			{High: 102, Low: 100, Close: 101},
+			{High: 101, Low: 97, Close: 98},
+		}
+		// true ranges of 2 and 4
+		assert.Equal(t, float64(3), averageTrueRange(history, 2))
+		exits := ExitRules{ATRStop: 2, ATRPeriod: 2, StopLoss: 0.01}.arm(history, 100, true, 0)
+		assert.Equal(t, float64(94), exits.stop)
+	})
+}
@@ -0,0 +1,176 @@
+package backtest
+
+import (
+	"math"
+)
+
+type Metrics struct {
+	TotalReturn      float64 `json:"total_return"`
+	AnnualisedReturn float64 `json:"annualised_return"`
+	// Volatility is the annualised standard deviation of bar returns, the
+	// ratios take the risk free rate to be zero
+	Volatility float64 `json:"volatility"`
+	Sharpe     float64 `json:"sharpe"`
+	Sortino    float64 `json:"sortino"`
+	Calmar     float64 `json:"calmar"`
+	// MaxDrawdown is the largest fall from a peak in equity as a fraction
+	// of it, MaxDrawdownDuration the longest time in milliseconds spent
+	// below a peak
+	MaxDrawdown         float64 `json:"max_drawdown"`
+	MaxDrawdownDuration int64   `json:"max_drawdown_duration"`
+	Trades              int     `json:"trades"`
+	HitRate             float64 `json:"hit_rate"`
+	// ProfitFactor is gross profit over gross loss, 0 without any losses
+	ProfitFactor float64 `json:"profit_factor"`
+	AverageWin   float64 `json:"average_win"`
+	AverageLoss  float64 `json:"average_loss"`
+	// Exposure is the share of bars a position was held over, Turnover is
+	// the value traded per year as a multiple of the average equity
+	Exposure float64 `json:"exposure"`
+	Turnover float64 `json:"turnover"`
+}
+
+// Report is a backtest's result along with its metrics, and the metrics
+// of buying and holding over the same data for comparison
+type Report struct {
+	Metrics    Metrics `json:"metrics"`
+	BuyAndHold Metrics `json:"buy_and_hold"`
+	*Result
+}
+
+// Drawdowns returns how far below its running peak equity is at each bar,
+// as a fraction of the peak
+func Drawdowns(equity []EquityPoint) []float64 {
+	drawdowns := make([]float64, len(equity))
+	peak := math.Inf(-1)
+	for i, point := range equity {
+		peak = math.Max(peak, point.Equity)
+		if peak > 0 {
+			drawdowns[i] = 1 - point.Equity/peak
+		}
+	}
+	return drawdowns
+}
+
+// Returns is the fractional change in equity over each bar
+func Returns(equity []EquityPoint) []float64 {
+	if len(equity) < 2 {
+		return nil
+	}
+	returns := make([]float64, len(equity)-1)
+	for i := range returns {
+		if equity[i].Equity != 0 {
+			returns[i] = equity[i+1].Equity/equity[i].Equity - 1
+		}
+	}
+	return returns
+}
+
+// BarsPerYear is how many bars a year of equity spans, going by its
+// average spacing
+func BarsPerYear(equity []EquityPoint) float64 {
+	if len(equity) < 2 {
+		return 0
+	}
+	duration := float64(equity[len(equity)-1].Timestamp - equity[0].Timestamp)
+	if duration <= 0 {
+		return 0
+	}
+	return yearMillis * float64(len(equity)-1) / duration
+}
+
+func ComputeMetrics(result *Result, initialCash float64) Metrics {
+	var metrics Metrics
+	equity := result.Equity
+	if len(equity) < 2 || initialCash <= 0 {
+		return metrics
+	}
+	final := equity[len(equity)-1].Equity
+	metrics.TotalReturn = final/initialCash - 1
+
+	duration := float64(equity[len(equity)-1].Timestamp - equity[0].Timestamp)
+	if duration <= 0 {
+		return metrics
+	}
+	barsPerYear := BarsPerYear(equity)
+	if final > 0 {
+		metrics.AnnualisedReturn = math.Pow(final/initialCash, yearMillis/duration) - 1
+	} else {
+		metrics.AnnualisedReturn = -1
+	}
+
+	returns := Returns(equity)
+	mean := float64(0)
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+	variance, downside := float64(0), float64(0)
+	for _, r := range returns {
+		variance += math.Pow(r-mean, 2)
+		downside += math.Pow(math.Min(r, 0), 2)
+	}
+	std := math.Sqrt(variance / float64(len(returns)))
+	downsideStd := math.Sqrt(downside / float64(len(returns)))
+	metrics.Volatility = std * math.Sqrt(barsPerYear)
+	if std > 0 {
+		metrics.Sharpe = mean / std * math.Sqrt(barsPerYear)
+	}
+	if downsideStd > 0 {
+		metrics.Sortino = mean / downsideStd * math.Sqrt(barsPerYear)
+	}
+
+	peakTime := equity[0].Timestamp
+	for i, dd := range Drawdowns(equity) {
+		metrics.MaxDrawdown = math.Max(metrics.MaxDrawdown, dd)
+		if dd == 0 {
+			peakTime = equity[i].Timestamp
+		} else if equity[i].Timestamp-peakTime > metrics.MaxDrawdownDuration {
+			metrics.MaxDrawdownDuration = equity[i].Timestamp - peakTime
+		}
+	}
+	if metrics.MaxDrawdown > 0 {
+		metrics.Calmar = metrics.AnnualisedReturn / metrics.MaxDrawdown
+	}
+
+	var wins, losses int
+	var grossWin, grossLoss, traded float64
+	for _, trade := range result.Trades {
+		if trade.PnL > 0 {
+			wins++
+			grossWin += trade.PnL
+		} else {
+			losses++
+			grossLoss -= trade.PnL
+		}
+		traded += math.Abs(trade.Quantity) * (trade.EntryPrice + trade.ExitPrice)
+	}
+	metrics.Trades = len(result.Trades)
+	if metrics.Trades > 0 {
+		metrics.HitRate = float64(wins) / float64(metrics.Trades)
+	}
+	if wins > 0 {
+		metrics.AverageWin = grossWin / float64(wins)
+	}
+	if losses > 0 {
+		metrics.AverageLoss = grossLoss / float64(losses)
+	}
+	if grossLoss > 0 {
+		metrics.ProfitFactor = grossWin / grossLoss
+	}
+
+	held := 0
+	meanEquity := float64(0)
+	for _, point := range equity {
+		if point.Position != 0 {
+			held++
+		}
+		meanEquity += point.Equity
+	}
+	meanEquity /= float64(len(equity))
+	metrics.Exposure = float64(held) / float64(len(equity))
+	if meanEquity > 0 {
+		metrics.Turnover = traded / meanEquity * yearMillis / duration
+	}
+	return metrics
+}
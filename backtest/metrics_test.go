@@ -0,0 +1,38 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeMetrics(t *testing.T) {
+	const day = int64(24 * 60 * 60 * 1000)
+	values := []float64{100, 110, 99, 104.5, 121}
+	equity := make([]EquityPoint, len(values))
+	for i, v := range values {
+		equity[i] = EquityPoint{Timestamp: int64(i) * day, Equity: v, Position: 1}
+	}
+	equity[len(equity)-1].Position = 0
+	result := &Result{
+		Equity: equity,
+		Trades: []Trade{
+			{Quantity: 1, EntryPrice: 10, ExitPrice: 12, PnL: 30},
+			{Quantity: -1, EntryPrice: 10, ExitPrice: 11, PnL: -10},
+		},
+	}
+
+	assert.InDeltaSlice(t, []float64{0, 0, 0.1, 0.05, 0}, Drawdowns(equity), 1e-9)
+
+	metrics := ComputeMetrics(result, 100)
+	assert.InDelta(t, 0.21, metrics.TotalReturn, 1e-9)
+	assert.InDelta(t, 0.1, metrics.MaxDrawdown, 1e-9)
+	assert.Equal(t, 2*day, metrics.MaxDrawdownDuration)
+	assert.Equal(t, 0.5, metrics.HitRate)
+	assert.Equal(t, float64(3), metrics.ProfitFactor)
+	assert.Equal(t, float64(30), metrics.AverageWin)
+	assert.Equal(t, float64(10), metrics.AverageLoss)
+	assert.Equal(t, 0.8, metrics.Exposure)
+	assert.Greater(t, metrics.Sharpe, float64(0))
+	assert.Greater(t, metrics.Sortino, metrics.Sharpe)
+}
@@ -0,0 +1,102 @@
+package backtest
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+type SizingType string
+
+const (
+	FixedFractionSizing    SizingType = "fixed"
+	VolatilityTargetSizing SizingType = "vol"
+	KellySizing            SizingType = "kelly"
+)
+
+func ToSizingType(input string) (SizingType, error) {
+	switch input {
+	case string(FixedFractionSizing):
+		return FixedFractionSizing, nil
+	case string(VolatilityTargetSizing):
+		return VolatilityTargetSizing, nil
+	case string(KellySizing):
+		return KellySizing, nil
+	}
+	return FixedFractionSizing, errors.New("invalid sizing type specified")
+}
+
+// Sizer decides what fraction of equity a new position should be worth,
+// from the history up to the bar the decision is made at
+type Sizer interface {
+	Size(history []record.Market, prediction *model.Prediction) float64
+}
+
+type FixedFraction struct {
+	Fraction float64
+}
+
+func (s FixedFraction) Size(_ []record.Market, _ *model.Prediction) float64 {
+	return s.Fraction
+}
+
+// VolatilityTarget sizes positions so their annualised volatility, going
+// by the last Lookback bars, is Target. Positions are capped at MaxFraction.
+type VolatilityTarget struct {
+	Target      float64
+	Lookback    int
+	MaxFraction float64
+}
+
+func (s VolatilityTarget) Size(history []record.Market, _ *model.Prediction) float64 {
+	vol := annualisedVolatility(history, s.Lookback)
+	if vol == 0 {
+		return s.MaxFraction
+	}
+	return math.Min(s.Target/vol, s.MaxFraction)
+}
+
+// Kelly sizes positions by the Kelly criterion for an even money bet won
+// with the prediction's confidence, multiplied by Scale and capped at
+// MaxFraction
+type Kelly struct {
+	Scale       float64
+	MaxFraction float64
+}
+
+func (s Kelly) Size(_ []record.Market, prediction *model.Prediction) float64 {
+	fraction := s.Scale * (2*prediction.Confidence - 1)
+	return math.Max(0, math.Min(fraction, s.MaxFraction))
+}
+
+// annualisedVolatility is the standard deviation of the log returns of
+// the last lookback bars, scaled up to a year by the bars' spacing
+func annualisedVolatility(history []record.Market, lookback int) float64 {
+	if lookback > len(history)-1 {
+		lookback = len(history) - 1
+	}
+	if lookback < 2 {
+		return 0
+	}
+	recent := history[len(history)-lookback-1:]
+	returns := make([]float64, lookback)
+	mean := float64(0)
+	for i := range returns {
+		returns[i] = math.Log(recent[i+1].Close / recent[i].Close)
+		mean += returns[i]
+	}
+	mean /= float64(lookback)
+	variance := float64(0)
+	for _, r := range returns {
+		variance += math.Pow(r-mean, 2)
+	}
+	variance /= float64(lookback - 1)
+
+	barDuration := float64(recent[len(recent)-1].Timestamp-recent[0].Timestamp) / float64(lookback)
+	if barDuration <= 0 {
+		return 0
+	}
+	return math.Sqrt(variance * yearMillis / barDuration)
+}
@@ -1,30 +1,200 @@
 package backtest
 
 import (
+	"errors"
+	"math"
+
 	"github.com/hubertkaluzny/silly-trader/model"
 	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
 )
 
-// EvaluateBuyHold expects history to come pre-normalised
-// returns whether to buy :)
-func EvaluateBuyHold(m *model.CompressionModel, curHistory []record.Market) (bool, error) {
-	observation := curHistory[m.SpliceOptions.Period:]
-	results, err := m.PredictResult(observation, 9)
-	if err != nil {
-		return false, err
-	}
-
-	// assuming item results are z-scores
-	// weighted result by distance
-	buyFreq := float64(0)
-	sellFreq := float64(0)
-	for _, res := range results {
-		if res.Item.Splice.Result > 1 {
-			buyFreq += 1 / res.Distance
-		} else if res.Item.Splice.Result < 1 {
-			sellFreq += 1 / res.Distance
+type OrderType string
+
+const (
+	MarketOrder OrderType = "market"
+	LimitOrder  OrderType = "limit"
+	StopOrder   OrderType = "stop"
+)
+
+// Order replaces the current position with one worth Target of equity,
+// negative for shorts. Orders are only good for the bar after they are
+// placed. Limit and stop orders fill once the bar trades through Price.
+type Order struct {
+	Type   OrderType
+	Target float64
+	Price  float64
+}
+
+// fill returns the price the order fills at within bar, if it fills at
+// all. buying is whether filling the order means buying.
+func (o Order) fill(bar record.Market, buying bool) (float64, bool) {
+	switch o.Type {
+	case LimitOrder:
+		if buying && bar.Low <= o.Price {
+			return math.Min(bar.Open, o.Price), true
+		}
+		if !buying && bar.High >= o.Price {
+			return math.Max(bar.Open, o.Price), true
+		}
+		return 0, false
+	case StopOrder:
+		if buying && bar.High >= o.Price {
+			return math.Max(bar.Open, o.Price), true
+		}
+		if !buying && bar.Low <= o.Price {
+			return math.Min(bar.Open, o.Price), true
+		}
+		return 0, false
+	}
+	return bar.Open, true
+}
+
+// Strategy decides what orders to place at the close of each bar, given
+// the history up to and including that bar and the current position. At
+// most one of the orders fills, the first one to trigger.
+type Strategy interface {
+	Decide(history []record.Market, m model.Model, position float64) ([]Order, error)
+}
+
+type StrategyType string
+
+const (
+	KNNVoteStrategy        StrategyType = "vote"
+	ExpectedReturnStrategy StrategyType = "expected"
+	BuyAndHoldStrategy     StrategyType = "buyhold"
+)
+
+func ToStrategyType(input string) (StrategyType, error) {
+	switch input {
+	case string(KNNVoteStrategy):
+		return KNNVoteStrategy, nil
+	case string(ExpectedReturnStrategy):
+		return ExpectedReturnStrategy, nil
+	case string(BuyAndHoldStrategy):
+		return BuyAndHoldStrategy, nil
+	}
+	return KNNVoteStrategy, errors.New("invalid strategy type specified")
+}
+
+// availableNeighbours returns the k nearest neighbours whose results were
+// all known by asOf, widening the search while later items crowd them out
+func availableNeighbours(m model.Model, observation record.Model, k int, asOf int64) ([]*model.Neighbour, error) {
+	for n := k; ; n *= 2 {
+		neighbours, err := m.Neighbours(observation, n)
+		if err != nil {
+			return nil, err
+		}
+		var available []*model.Neighbour
+		for _, neighbour := range neighbours {
+			if neighbour.Item.ResultTime <= asOf {
+				available = append(available, neighbour)
+			}
+			if len(available) == k {
+				break
+			}
+		}
+		if len(available) == k || len(neighbours) < n {
+			return available, nil
 		}
 	}
+}
 
-	return buyFreq > sellFreq, nil
+// predict normalises the trailing period of history like the model's
+// splices, and predicts from the neighbours that were known by its last
+// bar, so data the model was built from can also be backtested. It
+// returns nil while there isn't enough history or known neighbours yet.
+func predict(history []record.Market, m model.Model, opts model.PredictionOpts) (*model.Prediction, error) {
+	if len(history) < m.Options().Period {
+		return nil, nil
+	}
+	observation, err := splicer.Observation(history, m.Options())
+	if err != nil {
+		return nil, err
+	}
+	neighbours, err := availableNeighbours(m, observation, opts.NearestN, history[len(history)-1].Timestamp)
+	if err != nil || len(neighbours) == 0 {
+		return nil, err
+	}
+	return model.PredictFromNeighbours(neighbours, m.Options(), opts)
+}
+
+// signalOrders turns a signal into a market order, if it changes the
+// position's direction
+func signalOrders(signal int, size float64, allowShort bool, position float64) []Order {
+	switch {
+	case signal == model.Buy && position <= 0:
+		return []Order{{Type: MarketOrder, Target: size}}
+	case signal == model.Sell && position > 0 && !allowShort:
+		return []Order{{Type: MarketOrder, Target: 0}}
+	case signal == model.Sell && position >= 0 && allowShort:
+		return []Order{{Type: MarketOrder, Target: -size}}
+	}
+	return nil
+}
+
+// positionSize is the fraction of equity a new position is worth, a
+// strategy without a Sizer trades at full size
+func positionSize(sizer Sizer, history []record.Market, prediction *model.Prediction) float64 {
+	if sizer == nil {
+		return 1
+	}
+	return sizer.Size(history, prediction)
+}
+
+// KNNVote trades the class most of the nearest neighbours' weight voted for
+type KNNVote struct {
+	Prediction model.PredictionOpts
+	Sizer      Sizer
+	AllowShort bool
+}
+
+func (s KNNVote) Decide(history []record.Market, m model.Model, position float64) ([]Order, error) {
+	opts := s.Prediction
+	opts.Strategy = model.DiscreteWNN
+	prediction, err := predict(history, m, opts)
+	if err != nil || prediction == nil {
+		return nil, err
+	}
+	return signalOrders(prediction.Signal, positionSize(s.Sizer, history, prediction), s.AllowShort, position), nil
+}
+
+// ExpectedReturn trades when the neighbours' expected result is beyond
+// the prediction thresholds. With a positive EntryOffset it enters with a
+// limit order that fraction better than the last close.
+type ExpectedReturn struct {
+	Prediction  model.PredictionOpts
+	Sizer       Sizer
+	AllowShort  bool
+	EntryOffset float64
+}
+
+func (s ExpectedReturn) Decide(history []record.Market, m model.Model, position float64) ([]Order, error) {
+	prediction, err := predict(history, m, s.Prediction)
+	if err != nil || prediction == nil {
+		return nil, err
+	}
+	signal := s.Prediction.Classify(prediction.Expected)
+	orders := signalOrders(signal, positionSize(s.Sizer, history, prediction), s.AllowShort, position)
+	if len(orders) == 0 || s.EntryOffset <= 0 || orders[0].Target == 0 {
+		return orders, nil
+	}
+	last := history[len(history)-1].Close
+	orders[0].Type = LimitOrder
+	if orders[0].Target > 0 {
+		orders[0].Price = last * (1 - s.EntryOffset)
+	} else {
+		orders[0].Price = last * (1 + s.EntryOffset)
+	}
+	return orders, nil
+}
+
+// BuyAndHold goes long on the first bar and stays there, as a benchmark
+type BuyAndHold struct{}
+
+func (BuyAndHold) Decide(_ []record.Market, _ model.Model, position float64) ([]Order, error) {
+	if position != 0 {
+		return nil, nil
+	}
+	return []Order{{Type: MarketOrder, Target: 1}}, nil
 }
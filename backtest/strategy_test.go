@@ -0,0 +1,56 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+func TestOrderFill(t *testing.T) {
+	bar := record.Market{Open: 100, High: 105, Low: 95, Close: 102}
+	tests := []struct {
+		name   string
+		order  Order
+		buying bool
+		price  float64
+		filled bool
+	}{
+		{"market fills at open", Order{Type: MarketOrder}, true, 100, true},
+		{"buy limit within range", Order{Type: LimitOrder, Price: 97}, true, 97, true},
+		{"buy limit above open fills at open", Order{Type: LimitOrder, Price: 101}, true, 100, true},
+		{"buy limit below low", Order{Type: LimitOrder, Price: 94}, true, 0, false},
+		{"sell limit within range", Order{Type: LimitOrder, Price: 104}, false, 104, true},
+		{"sell limit above high", Order{Type: LimitOrder, Price: 106}, false, 0, false},
+		{"buy stop within range", Order{Type: StopOrder, Price: 103}, true, 103, true},
+		{"buy stop below open fills at open", Order{Type: StopOrder, Price: 98}, true, 100, true},
+		{"sell stop within range", Order{Type: StopOrder, Price: 96}, false, 96, true},
+		{"sell stop below low", Order{Type: StopOrder, Price: 90}, false, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			price, filled := test.order.fill(bar, test.buying)
+			assert.Equal(t, test.filled, filled)
+			assert.Equal(t, test.price, price)
+		})
+	}
+}
+
+func TestStrategiesWithoutSizer(t *testing.T) {
+	history := []record.Market{
+		{Timestamp: 0, Open: 100, High: 101, Low: 99, Close: 100},
+		{Timestamp: 10, Open: 100, High: 102, Low: 99, Close: 101},
+	}
+	m := &fixedModel{items: []model.Item{{Result: 1, ResultTime: 10}}}
+	opts := model.PredictionOpts{Strategy: model.ContinousWNN, NearestN: 1}
+
+	orders, err := KNNVote{Prediction: opts}.Decide(history, m, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []Order{{Type: MarketOrder, Target: 1}}, orders)
+
+	orders, err = ExpectedReturn{Prediction: opts}.Decide(history, m, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []Order{{Type: MarketOrder, Target: 1}}, orders)
+}
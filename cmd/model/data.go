@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+func readMarketFile(dataFilePath string) ([]record.Market, error) {
+	dataFile, err := os.Open(dataFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+
+	fmt.Println("Opening data file...")
+	reader := csv.NewReader(dataFile)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	parsedRecs := make([]record.Market, len(records)-1)
+	for i, rec := range records[1:] {
+		parsed, err := record.UnserialiseMarket(rec)
+		if err != nil {
+			return nil, err
+		}
+		parsedRecs[i] = *parsed
+	}
+	fmt.Printf("Parsed %d records.\n", len(parsedRecs))
+	return parsedRecs, nil
+}
+
+func printProgress(done, total int) {
+	fmt.Printf("\rAdded %d/%d splices to model", done, total)
+	if done == total {
+		fmt.Println()
+	}
+}
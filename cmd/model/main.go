@@ -1,14 +1,18 @@
 package main
 
 import (
-	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-echarts/go-echarts/v2/components"
 	"github.com/urfave/cli/v2"
 
+	"github.com/hubertkaluzny/silly-trader/backtest"
 	"github.com/hubertkaluzny/silly-trader/eval"
 	"github.com/hubertkaluzny/silly-trader/model"
 	"github.com/hubertkaluzny/silly-trader/record"
@@ -23,138 +27,786 @@ func main() {
 	const NormalisationFlag = "normalisation"
 	const CompressionEncodingFlag = "cencoding"
 	const ModelCombineStrategyFlag = "combine"
+	const CompressionLevelFlag = "compression-level"
 	const DownsampleFlag = "downsample"
+	const ModelTypeFlag = "model-type"
+	const DTWWindowFlag = "dtw-window"
+	const StrategyFlag = "strategy"
+	const NearestNFlag = "nearest"
+	const BuyThresholdFlag = "buy-threshold"
+	const SellThresholdFlag = "sell-threshold"
+	const LabelFlag = "label"
+	const DeadZoneFlag = "dead-zone"
+	const TakeProfitFlag = "take-profit"
+	const StopLossFlag = "stop-loss"
+	const HorizonsFlag = "horizons"
+	const HorizonFlag = "horizon"
+	const TestStartFlag = "test-start"
+	const TestWindowFlag = "test-window"
+	const TrainWindowFlag = "train-window"
+	const FoldsFlag = "folds"
+	const EmbargoFlag = "embargo"
+	const OverlapWindowFlag = "overlap-window"
+	const InitialCashFlag = "initial-cash"
+	const CommissionFlag = "commission"
+	const CommissionRateFlag = "commission-rate"
+	const SpreadBpsFlag = "spread-bps"
+	const SlippageBpsFlag = "slippage-bps"
+	const SizingFlag = "sizing"
+	const FractionFlag = "fraction"
+	const VolTargetFlag = "vol-target"
+	const VolLookbackFlag = "vol-lookback"
+	const KellyScaleFlag = "kelly-scale"
+	const MaxFractionFlag = "max-fraction"
+	const ShortFlag = "short"
+	const BorrowRateFlag = "borrow-rate"
+	const RuleFlag = "rule"
+	const EntryOffsetFlag = "entry-offset"
+	const ChartFlag = "chart"
+	const ATRStopFlag = "atr-stop"
+	const ATRPeriodFlag = "atr-period"
+	const TrailingStopFlag = "trailing-stop"
+	const MaxHoldFlag = "max-hold"
+	const WorkersFlag = "workers"
+	const SortFlag = "sort"
+	const SamplesFlag = "samples"
+	const SeedFlag = "seed"
+	const BlockSizeFlag = "block-size"
+	const ConfidenceFlag = "confidence"
+	const BucketsFlag = "buckets"
+	const MethodFlag = "method"
+	const PerplexityFlag = "perplexity"
+	const IterationsFlag = "iterations"
+	const LinkageFlag = "linkage"
+	const ClustersFlag = "clusters"
+	const EndFlag = "end"
+	const BucketingFlag = "bucketing"
+	const BucketSizeFlag = "bucket-size"
+	const ExcludeSelfFlag = "exclude-self"
+	const ExcludeOverlappingFlag = "exclude-overlapping"
+	const EncodingsFlag = "encodings"
+	const LevelsFlag = "levels"
+	const QueriesFlag = "queries"
+
+	// spliceFlags describe how market data is cut into splices, as read by
+	// spliceOptions
+	spliceFlags := []cli.Flag{
+		&cli.IntFlag{
+			Name:  PeriodFlag,
+			Value: eval.DefaultVariant.SpliceOptions.Period,
+		},
+		&cli.IntFlag{
+			Name:  ResultNFlag,
+			Value: eval.DefaultVariant.SpliceOptions.ResultN,
+		},
+		&cli.IntSliceFlag{
+			Name:  HorizonsFlag,
+			Usage: "additional horizons to record results for",
+		},
+		&cli.IntFlag{
+			Name:  SkipNFlag,
+			Value: eval.DefaultVariant.SpliceOptions.SkipN,
+		},
+		&cli.StringFlag{
+			Name:  NormalisationFlag,
+			Value: string(eval.DefaultVariant.SpliceOptions.NormalisationType),
+		},
+		&cli.StringFlag{
+			Name:  LabelFlag,
+			Value: string(eval.DefaultVariant.SpliceOptions.LabelType),
+		},
+		&cli.Float64Flag{
+			Name: DeadZoneFlag,
+		},
+		&cli.Float64Flag{
+			Name:  TakeProfitFlag,
+			Value: eval.DefaultVariant.SpliceOptions.TakeProfit,
+		},
+		&cli.Float64Flag{
+			Name:  StopLossFlag,
+			Value: eval.DefaultVariant.SpliceOptions.StopLoss,
+		},
+	}
+	combineFlag := &cli.StringFlag{
+		Name:  ModelCombineStrategyFlag,
+		Value: string(eval.DefaultVariant.Combine),
+	}
+	modelFlags := append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  ModelTypeFlag,
+			Value: string(eval.DefaultVariant.ModelType),
+		},
+		&cli.StringFlag{
+			Name:  CompressionEncodingFlag,
+			Value: string(eval.DefaultVariant.Encoding),
+		},
+		combineFlag,
+		&cli.IntFlag{
+			Name:  CompressionLevelFlag,
+			Usage: "libdeflate level to compress at from 1 to 12, defaults to 12 for items and 6 for distance maps",
+		},
+		&cli.IntFlag{
+			Name:  DTWWindowFlag,
+			Value: eval.DefaultVariant.DTWWindow,
+		},
+	}, spliceFlags...)
+
+	spliceOptions := func(ctx *cli.Context) (splicer.SpliceOptions, error) {
+		normalisationType, err := record.ToNormalisationType(ctx.String(NormalisationFlag))
+		if err != nil {
+			return splicer.SpliceOptions{}, err
+		}
+		labelType, err := splicer.ToLabelType(ctx.String(LabelFlag))
+		if err != nil {
+			return splicer.SpliceOptions{}, err
+		}
+		return splicer.SpliceOptions{
+			Period:            ctx.Int(PeriodFlag),
+			ResultN:           ctx.Int(ResultNFlag),
+			Horizons:          ctx.IntSlice(HorizonsFlag),
+			SkipN:             ctx.Int(SkipNFlag),
+			NormalisationType: normalisationType,
+			LabelType:         labelType,
+			DeadZone:          ctx.Float64(DeadZoneFlag),
+			TakeProfit:        ctx.Float64(TakeProfitFlag),
+			StopLoss:          ctx.Float64(StopLossFlag),
+		}, nil
+	}
+	// modelFactory creates empty models of the type described by modelFlags
+	modelFactory := func(ctx *cli.Context) (eval.ModelFactory, error) {
+		modelType, err := model.ToModelType(ctx.String(ModelTypeFlag))
+		if err != nil {
+			return nil, err
+		}
+		encodingType, err := model.ToCompressionEncodingType(ctx.String(CompressionEncodingFlag))
+		if err != nil {
+			return nil, err
+		}
+		combineStrat, err := record.ToCombineStrategy(ctx.String(ModelCombineStrategyFlag))
+		if err != nil {
+			return nil, err
+		}
+		opts, err := spliceOptions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		dtwWindow := ctx.Int(DTWWindowFlag)
+		compressionLevel := ctx.Int(CompressionLevelFlag)
+		return func() model.Model {
+			switch modelType {
+			case model.Cosine:
+				return model.NewCosineModel(opts)
+			case model.Euclidean:
+				return model.NewEuclideanModel(opts)
+			case model.Correlation:
+				return model.NewCorrelationModel(opts)
+			case model.DTW:
+				return model.NewDTWModel(opts, dtwWindow)
+			}
+			m := model.NewCompressionModel(opts, encodingType, combineStrat)
+			m.CompressionLevel = compressionLevel
+			return m
+		}, nil
+	}
+
+	predictionFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  StrategyFlag,
+			Value: string(eval.DefaultVariant.Strategy),
+		},
+		&cli.IntFlag{
+			Name:  NearestNFlag,
+			Value: eval.DefaultVariant.NearestN,
+		},
+		&cli.IntFlag{
+			Name:  HorizonFlag,
+			Usage: "defaults to the model's resultn",
+		},
+		&cli.Float64Flag{
+			Name:  BuyThresholdFlag,
+			Usage: "defaults to the threshold for the model's label type",
+		},
+		&cli.Float64Flag{
+			Name:  SellThresholdFlag,
+			Usage: "defaults to the threshold for the model's label type",
+		},
+	}
+	predictionOpts := func(ctx *cli.Context, spliceOpts splicer.SpliceOptions) (model.PredictionOpts, error) {
+		strategy, err := model.ToPredictionStrategy(ctx.String(StrategyFlag))
+		if err != nil {
+			return model.PredictionOpts{}, err
+		}
+		if ctx.Int(NearestNFlag) < 1 {
+			return model.PredictionOpts{}, errors.New("number of neighbours must be positive")
+		}
+		opts := model.PredictionOpts{
+			Strategy: strategy,
+			NearestN: ctx.Int(NearestNFlag),
+			Horizon:  ctx.Int(HorizonFlag),
+		}
+		opts.BuyThreshold, opts.SellThreshold = splicer.Thresholds(spliceOpts)
+		if ctx.IsSet(BuyThresholdFlag) {
+			opts.BuyThreshold = ctx.Float64(BuyThresholdFlag)
+		}
+		if ctx.IsSet(SellThresholdFlag) {
+			opts.SellThreshold = ctx.Float64(SellThresholdFlag)
+		}
+		return opts, nil
+	}
+
+	walkForwardFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     TestStartFlag,
+			Usage:    "date the first test window starts, as YYYY-MM-DD",
+			Required: true,
+		},
+		&cli.DurationFlag{
+			Name:  TestWindowFlag,
+			Value: 30 * 24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:  TrainWindowFlag,
+			Usage: "how far back to train from each test window, defaults to all prior data",
+		},
+		&cli.IntFlag{
+			Name:  FoldsFlag,
+			Usage: "defaults to as many as the data allows",
+		},
+	}
+	walkForwardOpts := func(ctx *cli.Context) (eval.WalkForwardOpts, error) {
+		testStart, err := time.Parse("2006-01-02", ctx.String(TestStartFlag))
+		if err != nil {
+			return eval.WalkForwardOpts{}, err
+		}
+		return eval.WalkForwardOpts{
+			TestStart:     testStart.UnixMilli(),
+			TestDuration:  ctx.Duration(TestWindowFlag).Milliseconds(),
+			TrainDuration: ctx.Duration(TrainWindowFlag).Milliseconds(),
+			Folds:         ctx.Int(FoldsFlag),
+		}, nil
+	}
+
+	// heldOutPredictions predicts the data file in the second argument with
+	// the model file in the first
+	heldOutPredictions := func(ctx *cli.Context, strategy model.PredictionStrategy) ([]eval.PredictionRecord, error) {
+		modelFilePath := ctx.Args().Get(0)
+		dataFilePath := ctx.Args().Get(1)
+
+		importedModel, err := model.LoadModel(modelFilePath)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+		predictOpts, err := predictionOpts(ctx, importedModel.Options())
+		if err != nil {
+			return nil, err
+		}
+		if strategy != "" {
+			predictOpts.Strategy = strategy
+		}
+		parsedRecs, err := readMarketFile(dataFilePath)
+		if err != nil {
+			return nil, err
+		}
+		records, err := eval.HeldOutPredictions(importedModel, parsedRecs, predictOpts)
+		if err != nil {
+			return nil, err
+		}
+		metrics := eval.ComputeMetrics(records)
+		fmt.Printf("Predicted %d held out splices, accuracy %.4f\n", metrics.Count, metrics.Accuracy)
+		return records, nil
+	}
 
 	app := &cli.App{
 		Name: "model",
 		Commands: []*cli.Command{
 			{
-				Name: "create",
-				Flags: []cli.Flag{
+				Name:  "create",
+				Flags: modelFlags,
+				Action: func(ctx *cli.Context) error {
+					newModel, err := modelFactory(ctx)
+					if err != nil {
+						return err
+					}
+					dataFilePath := ctx.Args().Get(0)
+					parsedRecs, err := readMarketFile(dataFilePath)
+					if err != nil {
+						return err
+					}
+
+					importedModel := newModel()
+					fmt.Printf("Splicing data with options: %+v\n", importedModel.Options())
+					importedModel.SetBuildOptions(model.BuildOptions{
+						Progress: printProgress,
+					})
+					err = importedModel.AddMarketData(parsedRecs)
+					if err != nil {
+						return err
+					}
+
+					fmt.Println("Data added to model, saving...")
+					outputFilePath := ctx.Args().Get(1)
+					return importedModel.SaveToFile(outputFilePath)
+				},
+			},
+			{
+				Name: "add",
+				Action: func(ctx *cli.Context) error {
+					dataFilePath := ctx.Args().Get(0)
+					modelFilePath := ctx.Args().Get(1)
+
+					importedModel, err := model.LoadModel(modelFilePath)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+					parsedRecs, err := readMarketFile(dataFilePath)
+					if err != nil {
+						return err
+					}
+
+					importedModel.SetBuildOptions(model.BuildOptions{
+						Progress: printProgress,
+					})
+					err = importedModel.AddMarketData(parsedRecs)
+					if err != nil {
+						return err
+					}
+
+					fmt.Println("Data added to model, saving...")
+
+					return importedModel.SaveToFile(modelFilePath)
+				},
+			},
+			{
+				Name:  "predict",
+				Flags: predictionFlags,
+				Action: func(ctx *cli.Context) error {
+					modelFilePath := ctx.Args().Get(0)
+					dataFilePath := ctx.Args().Get(1)
+
+					importedModel, err := model.LoadModel(modelFilePath)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+					opts, err := predictionOpts(ctx, importedModel.Options())
+					if err != nil {
+						return err
+					}
+
+					parsedRecs, err := readMarketFile(dataFilePath)
+					if err != nil {
+						return err
+					}
+
+					observation, err := splicer.Observation(parsedRecs, importedModel.Options())
+					if err != nil {
+						return err
+					}
+					prediction, err := importedModel.Predict(observation, opts)
+					if err != nil {
+						return err
+					}
+
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(prediction)
+				},
+			},
+			{
+				Name:  "explain",
+				Usage: "render the neighbours behind a prediction",
+				Flags: append([]cli.Flag{
+					&cli.TimestampFlag{
+						Name:   EndFlag,
+						Layout: time.RFC3339,
+						Usage:  "time the observation window ends at, defaults to the end of the data",
+					},
+				}, predictionFlags...),
+				Action: func(ctx *cli.Context) error {
+					modelFilePath := ctx.Args().Get(0)
+					dataFilePath := ctx.Args().Get(1)
+					outputFilePath := ctx.Args().Get(2)
+
+					importedModel, err := model.LoadModel(modelFilePath)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+					opts, err := predictionOpts(ctx, importedModel.Options())
+					if err != nil {
+						return err
+					}
+
+					parsedRecs, err := readMarketFile(dataFilePath)
+					if err != nil {
+						return err
+					}
+					if end := ctx.Timestamp(EndFlag); end != nil {
+						parsedRecs, _ = eval.SplitByTime(parsedRecs, end.UnixMilli()+1)
+					}
+
+					explanation, err := eval.Explain(importedModel, parsedRecs, opts)
+					if err != nil {
+						return err
+					}
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					err = encoder.Encode(explanation)
+					if err != nil {
+						return err
+					}
+
+					outputFile, err := os.Create(outputFilePath)
+					if err != nil {
+						return err
+					}
+
+					page := components.NewPage()
+					page.SetLayout(components.PageCenterLayout)
+					page.AddCharts(eval.VoteChart(explanation))
+					for _, line := range eval.NeighbourCharts(explanation) {
+						page.AddCharts(line)
+					}
+
+					err = page.Render(outputFile)
+					if err != nil {
+						return err
+					}
+
+					return outputFile.Close()
+				},
+			},
+			{
+				Name: "backtest",
+				Flags: append([]cli.Flag{
+					&cli.Float64Flag{
+						Name:  InitialCashFlag,
+						Value: 10000,
+					},
+					&cli.StringFlag{
+						Name:  RuleFlag,
+						Value: string(backtest.KNNVoteStrategy),
+						Usage: "trading strategy, one of vote, expected or buyhold",
+					},
+					&cli.StringFlag{
+						Name:  ChartFlag,
+						Usage: "path to render equity and drawdown charts to",
+					},
+					&cli.Float64Flag{
+						Name:  EntryOffsetFlag,
+						Usage: "enter expected strategy positions with limit orders this fraction better than the close",
+					},
+					&cli.Float64Flag{
+						Name:  CommissionFlag,
+						Usage: "charged on every fill",
+					},
+					&cli.Float64Flag{
+						Name:  CommissionRateFlag,
+						Usage: "fraction of every fill's value charged",
+					},
+					&cli.Float64Flag{
+						Name: SpreadBpsFlag,
+					},
+					&cli.Float64Flag{
+						Name: SlippageBpsFlag,
+					},
+					&cli.StringFlag{
+						Name:  SizingFlag,
+						Value: string(backtest.FixedFractionSizing),
+					},
+					&cli.Float64Flag{
+						Name:  FractionFlag,
+						Value: 1,
+						Usage: "fraction of equity per position for fixed sizing",
+					},
+					&cli.Float64Flag{
+						Name:  VolTargetFlag,
+						Value: 0.15,
+						Usage: "annualised volatility to target for vol sizing",
+					},
 					&cli.IntFlag{
-						Name:  PeriodFlag,
+						Name:  VolLookbackFlag,
 						Value: 24 * 7,
 					},
-					&cli.IntFlag{
-						Name:  ResultNFlag,
-						Value: 24,
+					&cli.Float64Flag{
+						Name:  KellyScaleFlag,
+						Value: 0.5,
+					},
+					&cli.Float64Flag{
+						Name:  MaxFractionFlag,
+						Value: 1,
+						Usage: "largest fraction of equity per position for vol and kelly sizing",
+					},
+					&cli.BoolFlag{
+						Name:  ShortFlag,
+						Usage: "short on sell signals rather than only closing longs",
+					},
+					&cli.Float64Flag{
+						Name:  BorrowRateFlag,
+						Usage: "annual cost of short positions as a fraction of their value",
+					},
+					&cli.Float64Flag{
+						Name:  StopLossFlag,
+						Usage: "fraction below entry to stop out of positions at",
+					},
+					&cli.Float64Flag{
+						Name:  ATRStopFlag,
+						Usage: "average true ranges below entry to stop out of positions at, instead of stop-loss",
 					},
 					&cli.IntFlag{
-						Name:  SkipNFlag,
-						Value: 3,
+						Name:  ATRPeriodFlag,
+						Value: 14,
 					},
-					&cli.StringFlag{
-						Name:  NormalisationFlag,
-						Value: string(record.ZScore),
+					&cli.Float64Flag{
+						Name:  TakeProfitFlag,
+						Usage: "fraction above entry to take profit at",
 					},
-					&cli.StringFlag{
-						Name:  CompressionEncodingFlag,
-						Value: string(model.RomanEncoding),
+					&cli.Float64Flag{
+						Name:  TrailingStopFlag,
+						Usage: "fraction below the best price since entry to stop out at",
 					},
-					&cli.StringFlag{
-						Name:  ModelCombineStrategyFlag,
-						Value: string(record.InterleaveCombine),
+					&cli.IntFlag{
+						Name:  MaxHoldFlag,
+						Usage: "bars to hold positions for at most, defaults to the prediction horizon and 0 holds indefinitely",
 					},
-				},
+				}, predictionFlags...),
 				Action: func(ctx *cli.Context) error {
-					normalisationType, err := record.ToNormalisationType(ctx.String(NormalisationFlag))
+					modelFilePath := ctx.Args().Get(0)
+					dataFilePath := ctx.Args().Get(1)
+					outputFilePath := ctx.Args().Get(2)
+
+					importedModel, err := model.LoadModel(modelFilePath)
 					if err != nil {
 						return err
 					}
-					dataFilePath := ctx.Args().Get(0)
+					fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
 
-					dataFile, err := os.Open(dataFilePath)
+					predictOpts, err := predictionOpts(ctx, importedModel.Options())
 					if err != nil {
 						return err
 					}
 
-					encodingType, err := model.ToCompressionEncodingType(ctx.String(CompressionEncodingFlag))
+					parsedRecs, err := readMarketFile(dataFilePath)
 					if err != nil {
 						return err
 					}
 
-					combineStrat, err := record.ToCombineStrategy(ctx.String(ModelCombineStrategyFlag))
+					sizingType, err := backtest.ToSizingType(ctx.String(SizingFlag))
 					if err != nil {
 						return err
 					}
+					var sizer backtest.Sizer
+					switch sizingType {
+					case backtest.FixedFractionSizing:
+						sizer = backtest.FixedFraction{
+							Fraction: ctx.Float64(FractionFlag),
+						}
+					case backtest.VolatilityTargetSizing:
+						sizer = backtest.VolatilityTarget{
+							Target:      ctx.Float64(VolTargetFlag),
+							Lookback:    ctx.Int(VolLookbackFlag),
+							MaxFraction: ctx.Float64(MaxFractionFlag),
+						}
+					case backtest.KellySizing:
+						sizer = backtest.Kelly{
+							Scale:       ctx.Float64(KellyScaleFlag),
+							MaxFraction: ctx.Float64(MaxFractionFlag),
+						}
+					}
 
-					fmt.Println("Opening data file...")
-					reader := csv.NewReader(dataFile)
-					records, err := reader.ReadAll()
+					strategyType, err := backtest.ToStrategyType(ctx.String(RuleFlag))
 					if err != nil {
 						return err
 					}
+					var strategy backtest.Strategy
+					switch strategyType {
+					case backtest.KNNVoteStrategy:
+						strategy = backtest.KNNVote{
+							Prediction: predictOpts,
+							Sizer:      sizer,
+							AllowShort: ctx.Bool(ShortFlag),
+						}
+					case backtest.ExpectedReturnStrategy:
+						strategy = backtest.ExpectedReturn{
+							Prediction:  predictOpts,
+							Sizer:       sizer,
+							AllowShort:  ctx.Bool(ShortFlag),
+							EntryOffset: ctx.Float64(EntryOffsetFlag),
+						}
+					case backtest.BuyAndHoldStrategy:
+						strategy = backtest.BuyAndHold{}
+					}
 
-					parsedRecs := make([]record.Market, len(records)-1)
-					for i, rec := range records[1:] {
-						parsed, err := record.UnserialiseMarket(rec)
-						if err != nil {
-							return err
+					backtestOpts := backtest.Options{
+						InitialCash: ctx.Float64(InitialCashFlag),
+						Strategy:    strategy,
+						Commission: backtest.FixedCommission{
+							PerTrade: ctx.Float64(CommissionFlag),
+							Rate:     ctx.Float64(CommissionRateFlag),
+						},
+						Fill: backtest.BpsFill{
+							SpreadBps:   ctx.Float64(SpreadBpsFlag),
+							SlippageBps: ctx.Float64(SlippageBpsFlag),
+						},
+						BorrowRate: ctx.Float64(BorrowRateFlag),
+						Exits: backtest.ExitRules{
+							StopLoss:     ctx.Float64(StopLossFlag),
+							ATRStop:      ctx.Float64(ATRStopFlag),
+							ATRPeriod:    ctx.Int(ATRPeriodFlag),
+							TakeProfit:   ctx.Float64(TakeProfitFlag),
+							TrailingStop: ctx.Float64(TrailingStopFlag),
+							MaxHolding:   ctx.Int(MaxHoldFlag),
+						},
+					}
+					if !ctx.IsSet(MaxHoldFlag) {
+						horizon := predictOpts.Horizon
+						if horizon == 0 {
+							horizon = importedModel.Options().ResultN
 						}
-						parsedRecs[i] = *parsed
+						backtestOpts.Exits.MaxHolding = backtest.HorizonHolding(horizon)
+					}
+					result, err := backtest.Run(importedModel, parsedRecs, backtestOpts)
+					if err != nil {
+						return err
+					}
+					backtestOpts.Strategy = backtest.BuyAndHold{}
+					backtestOpts.Exits = backtest.ExitRules{}
+					benchmark, err := backtest.Run(importedModel, parsedRecs, backtestOpts)
+					if err != nil {
+						return err
 					}
-					fmt.Printf("Parsed %d records.\n", len(parsedRecs))
-					opts := splicer.SpliceOptions{
-						Period:            ctx.Int(PeriodFlag),
-						ResultN:           ctx.Int(ResultNFlag),
-						SkipN:             ctx.Int(SkipNFlag),
-						NormalisationType: normalisationType,
+					report := backtest.Report{
+						Metrics:    backtest.ComputeMetrics(result, backtestOpts.InitialCash),
+						BuyAndHold: backtest.ComputeMetrics(benchmark, backtestOpts.InitialCash),
+						Result:     result,
 					}
-					fmt.Printf("Splicing data with options: %+v\n", opts)
-					importedModel := model.NewCompressionModel(opts, encodingType, combineStrat)
+					fmt.Printf("Made %d trades, return %.4f, sharpe %.4f, max drawdown %.4f\n", report.Metrics.Trades,
+						report.Metrics.TotalReturn, report.Metrics.Sharpe, report.Metrics.MaxDrawdown)
+					fmt.Printf("Buy and hold return %.4f, sharpe %.4f, max drawdown %.4f\n",
+						report.BuyAndHold.TotalReturn, report.BuyAndHold.Sharpe, report.BuyAndHold.MaxDrawdown)
 
-					err = importedModel.AddMarketData(parsedRecs)
+					outputFile, err := os.Create(outputFilePath)
+					if err != nil {
+						return err
+					}
+					encoder := json.NewEncoder(outputFile)
+					encoder.SetIndent("", "  ")
+					err = encoder.Encode(report)
+					if err != nil {
+						return err
+					}
+					err = outputFile.Close()
 					if err != nil {
 						return err
 					}
 
-					fmt.Println("Data added to model, saving...")
-					outputFilePath := ctx.Args().Get(1)
-					return importedModel.SaveToFile(outputFilePath)
+					chartFilePath := ctx.String(ChartFlag)
+					if chartFilePath == "" {
+						return nil
+					}
+					fmt.Println("Backtest charts generated, rendering output.")
+					chartFile, err := os.Create(chartFilePath)
+					if err != nil {
+						return err
+					}
+
+					page := components.NewPage()
+					page.SetLayout(components.PageCenterLayout)
+					page.AddCharts(
+						backtest.EquityChart(result, benchmark),
+						backtest.DrawdownChart(result, benchmark),
+					)
+
+					err = page.Render(chartFile)
+					if err != nil {
+						return err
+					}
+
+					return chartFile.Close()
 				},
 			},
 			{
-				Name: "add",
+				Name:  "sweep",
+				Usage: "score model variants from a sweep spec by walk-forward evaluation",
+				Flags: append([]cli.Flag{
+					&cli.IntFlag{
+						Name:  WorkersFlag,
+						Usage: "variants to evaluate at once, defaults to the number of CPUs",
+					},
+					&cli.StringFlag{
+						Name:  SortFlag,
+						Value: "accuracy",
+						Usage: "one of accuracy, spread, buy_precision or sell_precision",
+					},
+				}, walkForwardFlags...),
 				Action: func(ctx *cli.Context) error {
-					dataFilePath := ctx.Args().Get(0)
-					modelFilePath := ctx.Args().Get(1)
+					specFilePath := ctx.Args().Get(0)
+					dataFilePath := ctx.Args().Get(1)
+					outputFilePath := ctx.Args().Get(2)
 
-					importedModel, err := model.LoadCompressionModelFromFile(modelFilePath)
+					specFile, err := os.Open(specFilePath)
 					if err != nil {
 						return err
 					}
-					fmt.Printf("Loaded model with %d records.\n", len(importedModel.Items))
-
-					dataFile, err := os.Open(dataFilePath)
+					spec, err := eval.LoadSweepSpec(specFile)
+					specFile.Close()
+					if err != nil {
+						return err
+					}
+					variants, err := spec.Variants()
+					if err != nil {
+						return err
+					}
+					wfOpts, err := walkForwardOpts(ctx)
 					if err != nil {
 						return err
 					}
 
-					fmt.Println("Opening data file...")
-					reader := csv.NewReader(dataFile)
-					records, err := reader.ReadAll()
+					parsedRecs, err := readMarketFile(dataFilePath)
 					if err != nil {
 						return err
 					}
 
-					parsedRecs := make([]record.Market, len(records)-1)
-					for i, rec := range records[1:] {
-						parsed, err := record.UnserialiseMarket(rec)
-						if err != nil {
-							return err
+					fmt.Printf("Sweeping %d variants.\n", len(variants))
+					results := eval.Sweep(parsedRecs, variants, wfOpts, ctx.Int(WorkersFlag), func(done, total int) {
+						fmt.Printf("\rScored %d/%d variants", done, total)
+						if done == total {
+							fmt.Println()
+						}
+					})
+					err = eval.SortLeaderboard(results, ctx.String(SortFlag))
+					if err != nil {
+						return err
+					}
+					for i, r := range results {
+						if i == 5 {
+							break
 						}
-						parsedRecs[i] = *parsed
+						fmt.Printf("%d: %+v accuracy %.4f, spread %.4f %s\n", i+1, r.Variant, r.Metrics.Accuracy, r.Spread(), r.Error)
 					}
-					fmt.Printf("Parsed %d records.\n", len(parsedRecs))
 
-					err = importedModel.AddMarketData(parsedRecs)
+					outputFile, err := os.Create(outputFilePath)
+					if err != nil {
+						return err
+					}
+					if strings.HasSuffix(outputFilePath, ".csv") {
+						err = eval.WriteLeaderboardCSV(outputFile, results)
+					} else {
+						encoder := json.NewEncoder(outputFile)
+						encoder.SetIndent("", "  ")
+						err = encoder.Encode(results)
+					}
 					if err != nil {
 						return err
 					}
 
-					fmt.Println("Data added to model, saving...")
-
-					return importedModel.SaveToFile(modelFilePath)
+					return outputFile.Close()
 				},
 			},
 			{
@@ -173,11 +825,11 @@ func main() {
 							outputFilePath := ctx.Args().Get(1)
 							downsampleBy := ctx.Int(DownsampleFlag)
 
-							importedModel, err := model.LoadCompressionModelFromFile(modelFilePath)
+							importedModel, err := model.LoadModel(modelFilePath)
 							if err != nil {
 								return err
 							}
-							fmt.Printf("Loaded model with %d records.\n", len(importedModel.Items))
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
 
 							hmap, err := eval.CompressionHeatMap(importedModel, downsampleBy)
 							if err != nil {
@@ -208,18 +860,67 @@ func main() {
 						},
 					},
 					{
-						Name: "dstvar",
+						Name:  "dstvar",
+						Usage: "how the difference between the results of pairs of items varies with their distance",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  BucketingFlag,
+								Value: string(model.FixedBuckets),
+								Usage: "one of fixed or quantile",
+							},
+							&cli.Float64Flag{
+								Name:  BucketSizeFlag,
+								Value: 0.001,
+								Usage: "width of fixed buckets",
+							},
+							&cli.IntFlag{
+								Name:  BucketsFlag,
+								Value: 20,
+								Usage: "number of quantile buckets",
+							},
+							&cli.BoolFlag{
+								Name:  ExcludeSelfFlag,
+								Value: true,
+							},
+							&cli.BoolFlag{
+								Name: ExcludeOverlappingFlag,
+							},
+							&cli.DurationFlag{
+								Name:  OverlapWindowFlag,
+								Usage: "pairs this close to overlapping in time are also excluded",
+							},
+							&cli.IntFlag{
+								Name:  HorizonFlag,
+								Usage: "horizon of the results compared, defaults to the model's resultn",
+							},
+						},
 						Action: func(ctx *cli.Context) error {
 							modelFilePath := ctx.Args().Get(0)
 							outputFilePath := ctx.Args().Get(1)
 
-							importedModel, err := model.LoadCompressionModelFromFile(modelFilePath)
+							bucketing, err := model.ToDistanceBucketing(ctx.String(BucketingFlag))
+							if err != nil {
+								return err
+							}
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+							horizonIndex, err := importedModel.Options().HorizonIndex(ctx.Int(HorizonFlag))
 							if err != nil {
 								return err
 							}
-							fmt.Printf("Loaded model with %d records.\n", len(importedModel.Items))
 
-							hmap, err := eval.CompressionDstVarHistogram(importedModel)
+							buckets, err := model.DistanceVarianceHistogram(importedModel, model.DistanceVarianceOpts{
+								Bucketing:          bucketing,
+								BucketSize:         ctx.Float64(BucketSizeFlag),
+								Buckets:            ctx.Int(BucketsFlag),
+								ExcludeSelf:        ctx.Bool(ExcludeSelfFlag),
+								ExcludeOverlapping: ctx.Bool(ExcludeOverlappingFlag),
+								OverlapWindow:      ctx.Duration(OverlapWindowFlag).Milliseconds(),
+								HorizonIndex:       horizonIndex,
+							})
 							if err != nil {
 								return err
 							}
@@ -229,6 +930,13 @@ func main() {
 								return err
 							}
 
+							for _, b := range buckets {
+								if b.Count > 0 {
+									fmt.Printf("Distance %.4f-%.4f: %d pairs, result difference mean %.4f, variance %.4f\n",
+										b.Lower, b.Upper, b.Count, b.Mean, b.Variance)
+								}
+							}
+
 							fmt.Println("Distance result variance graph generated, rendering output.")
 							outputFile, err := os.Create(outputFilePath)
 							if err != nil {
@@ -237,7 +945,7 @@ func main() {
 
 							page := components.NewPage()
 							page.SetLayout(components.PageCenterLayout)
-							page.AddCharts(hmap)
+							page.AddCharts(eval.DistanceVarianceChart(buckets))
 
 							err = page.Render(outputFile)
 							if err != nil {
@@ -247,17 +955,806 @@ func main() {
 							return outputFile.Close()
 						},
 					},
+					{
+						Name: "horizons",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  NearestNFlag,
+								Value: eval.DefaultVariant.NearestN,
+							},
+							&cli.DurationFlag{
+								Name:  OverlapWindowFlag,
+								Usage: "neighbours this close to overlapping an item in time are also excluded",
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							modelFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+							if ctx.Int(NearestNFlag) < 1 {
+								return errors.New("number of neighbours must be positive")
+							}
+
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+							agreements, err := eval.NeighbourAgreementByHorizon(importedModel, ctx.Int(NearestNFlag),
+								ctx.Duration(OverlapWindowFlag).Milliseconds())
+							if err != nil {
+								return err
+							}
+							// save model distance map if it was calculated
+							err = importedModel.SaveToFile(modelFilePath)
+							if err != nil {
+								return err
+							}
+							for _, a := range agreements {
+								fmt.Printf("Horizon %d: agreement %.4f, correlation %.4f\n", a.Horizon, a.Agreement, a.Correlation)
+							}
+
+							fmt.Println("Horizon agreement graph generated, rendering output.")
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(eval.HorizonAgreementChart(agreements))
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "walkforward",
+						Flags: append(append(append([]cli.Flag{}, walkForwardFlags...), modelFlags...), predictionFlags...),
+						Action: func(ctx *cli.Context) error {
+							dataFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							newModel, err := modelFactory(ctx)
+							if err != nil {
+								return err
+							}
+							spliceOpts, err := spliceOptions(ctx)
+							if err != nil {
+								return err
+							}
+							predictOpts, err := predictionOpts(ctx, spliceOpts)
+							if err != nil {
+								return err
+							}
+							wfOpts, err := walkForwardOpts(ctx)
+							if err != nil {
+								return err
+							}
+							wfOpts.Prediction = predictOpts
+
+							parsedRecs, err := readMarketFile(dataFilePath)
+							if err != nil {
+								return err
+							}
+
+							report, err := eval.WalkForward(parsedRecs, newModel, wfOpts)
+							if err != nil {
+								return err
+							}
+							for _, fold := range report.Folds {
+								fmt.Printf("Fold %d: %d predictions from %d items, accuracy %.4f\n",
+									fold.Fold, fold.Metrics.Count, fold.TrainItems, fold.Metrics.Accuracy)
+							}
+							fmt.Printf("Overall: %d predictions, accuracy %.4f\n", report.Overall.Count, report.Overall.Accuracy)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+							encoder := json.NewEncoder(outputFile)
+							encoder.SetIndent("", "  ")
+							err = encoder.Encode(report)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name: "cv",
+						Flags: append([]cli.Flag{
+							&cli.IntFlag{
+								Name:  FoldsFlag,
+								Value: 5,
+							},
+							&cli.DurationFlag{
+								Name:  EmbargoFlag,
+								Usage: "how long after each test fold training items are still dropped",
+							},
+						}, predictionFlags...),
+						Action: func(ctx *cli.Context) error {
+							modelFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+							predictOpts, err := predictionOpts(ctx, importedModel.Options())
+							if err != nil {
+								return err
+							}
+							report, err := eval.CrossValidate(importedModel, eval.PurgedKFoldOpts{
+								Folds:   ctx.Int(FoldsFlag),
+								Embargo: ctx.Duration(EmbargoFlag).Milliseconds(),
+							}, predictOpts)
+							if err != nil {
+								return err
+							}
+							// save model distance map if it was calculated
+							err = importedModel.SaveToFile(modelFilePath)
+							if err != nil {
+								return err
+							}
+							for _, fold := range report.Folds {
+								fmt.Printf("Fold %d: %d predictions from %d items (%d purged, %d embargoed), accuracy %.4f\n",
+									fold.Fold, fold.Metrics.Count, fold.TrainItems, fold.Purged, fold.Embargoed, fold.Metrics.Accuracy)
+							}
+							fmt.Printf("Overall: %d predictions, accuracy %.4f\n", report.Overall.Count, report.Overall.Accuracy)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+							encoder := json.NewEncoder(outputFile)
+							encoder.SetIndent("", "  ")
+							err = encoder.Encode(report)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "loo",
+						Usage: "leave one out accuracy for every k up to --nearest",
+						Flags: append([]cli.Flag{
+							&cli.DurationFlag{
+								Name:  OverlapWindowFlag,
+								Usage: "neighbours this close to overlapping an item in time are also excluded",
+							},
+						}, predictionFlags...),
+						Action: func(ctx *cli.Context) error {
+							modelFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+							predictOpts, err := predictionOpts(ctx, importedModel.Options())
+							if err != nil {
+								return err
+							}
+							accuracies, err := eval.LeaveOneOutAccuracy(importedModel, ctx.Int(NearestNFlag),
+								ctx.Duration(OverlapWindowFlag).Milliseconds(), predictOpts)
+							if err != nil {
+								return err
+							}
+							// save model distance map if it was calculated
+							err = importedModel.SaveToFile(modelFilePath)
+							if err != nil {
+								return err
+							}
+							for _, a := range accuracies {
+								fmt.Printf("K %d: accuracy %.4f, majority class %.4f\n", a.K, a.Accuracy, a.Baseline)
+							}
+
+							fmt.Println("Leave one out accuracy graph generated, rendering output.")
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(eval.LOOAccuracyChart(accuracies))
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "significance",
+						Usage: "test leave one out accuracy against shuffled results and random neighbours",
+						Flags: append([]cli.Flag{
+							&cli.DurationFlag{
+								Name:  OverlapWindowFlag,
+								Usage: "neighbours this close to overlapping an item in time are also excluded",
+							},
+							&cli.IntFlag{
+								Name:  SamplesFlag,
+								Value: 1000,
+							},
+							&cli.Int64Flag{
+								Name:  SeedFlag,
+								Value: 1,
+							},
+						}, predictionFlags...),
+						Action: func(ctx *cli.Context) error {
+							modelFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+
+							predictOpts, err := predictionOpts(ctx, importedModel.Options())
+							if err != nil {
+								return err
+							}
+							result, err := eval.TestSkill(importedModel, ctx.Int(NearestNFlag),
+								ctx.Duration(OverlapWindowFlag).Milliseconds(), predictOpts,
+								ctx.Int(SamplesFlag), ctx.Int64(SeedFlag))
+							if err != nil {
+								return err
+							}
+							// save model distance map if it was calculated
+							err = importedModel.SaveToFile(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("K %d: accuracy %.4f\n", result.K, result.Permutation.Observed)
+							fmt.Printf("Permutation: null accuracy %.4f ± %.4f, p-value %.4f\n",
+								result.Permutation.NullMean, result.Permutation.NullStd, result.Permutation.PValue)
+							fmt.Printf("Random neighbours: null accuracy %.4f ± %.4f, p-value %.4f\n",
+								result.RandomNeighbours.NullMean, result.RandomNeighbours.NullStd, result.RandomNeighbours.PValue)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+							encoder := json.NewEncoder(outputFile)
+							encoder.SetIndent("", "  ")
+							err = encoder.Encode(result)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "bootstrap",
+						Usage: "block bootstrap confidence intervals for the returns of a backtest report",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  BlockSizeFlag,
+								Value: 24,
+								Usage: "consecutive bars resampled together",
+							},
+							&cli.IntFlag{
+								Name:  SamplesFlag,
+								Value: 1000,
+							},
+							&cli.Float64Flag{
+								Name:  ConfidenceFlag,
+								Value: 0.95,
+							},
+							&cli.Int64Flag{
+								Name:  SeedFlag,
+								Value: 1,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							reportFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							reportFile, err := os.Open(reportFilePath)
+							if err != nil {
+								return err
+							}
+							var report backtest.Report
+							err = json.NewDecoder(reportFile).Decode(&report)
+							reportFile.Close()
+							if err != nil {
+								return err
+							}
+							if report.Result == nil {
+								return errors.New("backtest report has no equity curve")
+							}
+
+							result, err := eval.BlockBootstrap(report.Result, ctx.Int(BlockSizeFlag),
+								ctx.Int(SamplesFlag), ctx.Float64(ConfidenceFlag), ctx.Int64(SeedFlag))
+							if err != nil {
+								return err
+							}
+							for _, interval := range []struct {
+								name     string
+								interval eval.Interval
+							}{{"Mean return", result.MeanReturn}, {"Total return", result.TotalReturn}, {"Sharpe", result.Sharpe}} {
+								fmt.Printf("%s: %.6f, %.0f%% interval [%.6f, %.6f]\n", interval.name, interval.interval.Observed,
+									result.Confidence*100, interval.interval.Lower, interval.interval.Upper)
+							}
+							fmt.Printf("p-value of the mean return against zero: %.4f\n", result.PValue)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+							encoder := json.NewEncoder(outputFile)
+							encoder.SetIndent("", "  ")
+							err = encoder.Encode(result)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "confusion",
+						Usage: "confusion matrix of predictions over a held out data file",
+						Flags: predictionFlags,
+						Action: func(ctx *cli.Context) error {
+							outputFilePath := ctx.Args().Get(2)
+
+							records, err := heldOutPredictions(ctx, "")
+							if err != nil {
+								return err
+							}
+							chart := eval.ConfusionMatrixChart(records)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(chart)
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "reliability",
+						Usage: "reliability diagram of prediction confidence over a held out data file",
+						Flags: append([]cli.Flag{
+							&cli.IntFlag{
+								Name:  BucketsFlag,
+								Value: 10,
+							},
+						}, predictionFlags...),
+						Action: func(ctx *cli.Context) error {
+							outputFilePath := ctx.Args().Get(2)
+
+							records, err := heldOutPredictions(ctx, "")
+							if err != nil {
+								return err
+							}
+							buckets, err := eval.Reliability(records, ctx.Int(BucketsFlag))
+							if err != nil {
+								return err
+							}
+							for _, b := range buckets {
+								fmt.Printf("Confidence %.2f-%.2f: %d predictions, mean confidence %.4f, hit rate %.4f\n",
+									b.Lower, b.Upper, b.Count, b.MeanConfidence, b.HitRate)
+							}
+							chart := eval.ReliabilityChart(buckets)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(chart)
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "roc",
+						Usage: "roc curves of cwnn expected results over a held out data file",
+						Flags: predictionFlags,
+						Action: func(ctx *cli.Context) error {
+							outputFilePath := ctx.Args().Get(2)
+
+							records, err := heldOutPredictions(ctx, model.ContinousWNN)
+							if err != nil {
+								return err
+							}
+							var curves []*eval.ROCCurve
+							for _, class := range []int{model.Buy, model.Sell} {
+								curve, err := eval.ROC(records, class)
+								if err != nil {
+									return err
+								}
+								fmt.Printf("%s: AUC %.4f\n", curve.Class, curve.AUC)
+								curves = append(curves, curve)
+							}
+							chart := eval.ROCChart(curves)
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(chart)
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "embedding",
+						Usage: "scatter plot of the distance map embedded in two dimensions",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  MethodFlag,
+								Value: string(eval.MDSEmbedding),
+								Usage: "one of mds or tsne",
+							},
+							&cli.IntFlag{
+								Name:  DownsampleFlag,
+								Value: 1,
+								Usage: "only embed every n-th item",
+							},
+							&cli.IntFlag{
+								Name:  HorizonFlag,
+								Usage: "horizon of the results items are coloured by, defaults to the model's resultn",
+							},
+							&cli.Float64Flag{
+								Name:  PerplexityFlag,
+								Value: 30,
+							},
+							&cli.IntFlag{
+								Name:  IterationsFlag,
+								Value: 500,
+							},
+							&cli.Int64Flag{
+								Name:  SeedFlag,
+								Value: 1,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							modelFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							method, err := eval.ToEmbeddingType(ctx.String(MethodFlag))
+							if err != nil {
+								return err
+							}
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+							horizonIndex, err := importedModel.Options().HorizonIndex(ctx.Int(HorizonFlag))
+							if err != nil {
+								return err
+							}
+
+							distances, indexes, err := eval.SampleDistances(importedModel, ctx.Int(DownsampleFlag))
+							if err != nil {
+								return err
+							}
+							// save model distance map if it was calculated
+							err = importedModel.SaveToFile(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Embedding %d items.\n", len(indexes))
+							var points [][2]float64
+							if method == eval.TSNEEmbedding {
+								points, err = eval.TSNE(distances, eval.TSNEOpts{
+									Perplexity: ctx.Float64(PerplexityFlag),
+									Iterations: ctx.Int(IterationsFlag),
+									Seed:       ctx.Int64(SeedFlag),
+								})
+							} else {
+								points, err = eval.ClassicalMDS(distances)
+							}
+							if err != nil {
+								return err
+							}
+
+							fmt.Println("Embedding generated, rendering output.")
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							byResult, byTime := eval.EmbeddingCharts(importedModel, points, indexes, horizonIndex)
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(byResult, byTime)
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
+					{
+						Name:  "cluster",
+						Usage: "hierarchically cluster the distance map and describe each cluster",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  LinkageFlag,
+								Value: string(eval.AverageLinkage),
+								Usage: "one of single, average, complete or ward",
+							},
+							&cli.IntFlag{
+								Name:  ClustersFlag,
+								Value: 4,
+							},
+							&cli.IntFlag{
+								Name:  DownsampleFlag,
+								Value: 1,
+								Usage: "only cluster every n-th item",
+							},
+							&cli.IntFlag{
+								Name:  HorizonFlag,
+								Usage: "horizon of the results clusters are described by, defaults to the model's resultn",
+							},
+							&cli.IntFlag{
+								Name:  BucketsFlag,
+								Value: 20,
+								Usage: "spans of time cluster membership is charted over",
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							modelFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+							reportFilePath := ctx.Args().Get(2)
+
+							linkage, err := eval.ToLinkageType(ctx.String(LinkageFlag))
+							if err != nil {
+								return err
+							}
+							importedModel, err := model.LoadModel(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
+							horizonIndex, err := importedModel.Options().HorizonIndex(ctx.Int(HorizonFlag))
+							if err != nil {
+								return err
+							}
+
+							distances, indexes, err := eval.SampleDistances(importedModel, ctx.Int(DownsampleFlag))
+							if err != nil {
+								return err
+							}
+							// save model distance map if it was calculated
+							err = importedModel.SaveToFile(modelFilePath)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Clustering %d items.\n", len(indexes))
+							merges, err := eval.Agglomerate(distances, linkage)
+							if err != nil {
+								return err
+							}
+							labels, err := eval.CutTree(merges, ctx.Int(ClustersFlag))
+							if err != nil {
+								return err
+							}
+							report := eval.NewClusterReport(importedModel, indexes, labels, linkage, horizonIndex)
+							for _, c := range report.Clusters {
+								fmt.Printf("Cluster %d: %d items from %s to %s, result mean %.4f, variance %.4f\n",
+									c.Cluster, c.Count,
+									time.UnixMilli(c.FirstTime).UTC().Format("2006-01-02"),
+									time.UnixMilli(c.LastTime).UTC().Format("2006-01-02"),
+									c.MeanResult, c.ResultVariance)
+							}
+
+							timeChart, err := eval.ClusterTimeChart(report, ctx.Int(BucketsFlag))
+							if err != nil {
+								return err
+							}
+
+							fmt.Println("Clusters generated, rendering output.")
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+
+							page := components.NewPage()
+							page.SetLayout(components.PageCenterLayout)
+							page.AddCharts(eval.DendrogramChart(merges), timeChart)
+
+							err = page.Render(outputFile)
+							if err != nil {
+								return err
+							}
+							err = outputFile.Close()
+							if err != nil {
+								return err
+							}
+
+							if reportFilePath == "" {
+								return nil
+							}
+							reportFile, err := os.Create(reportFilePath)
+							if err != nil {
+								return err
+							}
+							if strings.HasSuffix(reportFilePath, ".csv") {
+								err = report.WriteAssignmentsCSV(reportFile)
+							} else {
+								encoder := json.NewEncoder(reportFile)
+								encoder.SetIndent("", "  ")
+								err = encoder.Encode(report)
+							}
+							if err != nil {
+								return err
+							}
+
+							return reportFile.Close()
+						},
+					},
+					{
+						Name:  "encodings",
+						Usage: "compare compression encodings and levels on the same data",
+						Flags: append(append([]cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  EncodingsFlag,
+								Usage: "defaults to every encoding",
+							},
+							&cli.IntSliceFlag{
+								Name:  LevelsFlag,
+								Usage: "compression levels to try each encoding at, defaults to 1, 6 and 12",
+							},
+							&cli.DurationFlag{
+								Name:  OverlapWindowFlag,
+								Usage: "pairs of items this close to overlapping in time are excluded",
+							},
+							&cli.IntFlag{
+								Name:  QueriesFlag,
+								Value: 20,
+								Usage: "items to look up again to time queries",
+							},
+							combineFlag,
+						}, spliceFlags...), predictionFlags...),
+						Action: func(ctx *cli.Context) error {
+							dataFilePath := ctx.Args().Get(0)
+							outputFilePath := ctx.Args().Get(1)
+
+							opts, err := spliceOptions(ctx)
+							if err != nil {
+								return err
+							}
+							combineStrat, err := record.ToCombineStrategy(ctx.String(ModelCombineStrategyFlag))
+							if err != nil {
+								return err
+							}
+							predictOpts, err := predictionOpts(ctx, opts)
+							if err != nil {
+								return err
+							}
+							encodingsOpts := eval.EncodingsOpts{
+								SpliceOptions: opts,
+								Combine:       combineStrat,
+								Encodings:     model.CompressionEncodingTypes,
+								Levels:        model.CompressionLevels,
+								MaxK:          ctx.Int(NearestNFlag),
+								OverlapWindow: ctx.Duration(OverlapWindowFlag).Milliseconds(),
+								Prediction:    predictOpts,
+								Queries:       ctx.Int(QueriesFlag),
+							}
+							if ctx.IsSet(EncodingsFlag) {
+								encodingsOpts.Encodings = nil
+								for _, name := range ctx.StringSlice(EncodingsFlag) {
+									encoding, err := model.ToCompressionEncodingType(name)
+									if err != nil {
+										return err
+									}
+									encodingsOpts.Encodings = append(encodingsOpts.Encodings, encoding)
+								}
+							}
+							if ctx.IsSet(LevelsFlag) {
+								encodingsOpts.Levels = ctx.IntSlice(LevelsFlag)
+							}
+
+							parsedRecs, err := readMarketFile(dataFilePath)
+							if err != nil {
+								return err
+							}
+
+							reports, err := eval.CompareEncodings(parsedRecs, encodingsOpts, func(done, total int) {
+								fmt.Printf("\rCompared %d/%d encodings", done, total)
+								if done == total {
+									fmt.Println()
+								}
+							})
+							if err != nil {
+								return err
+							}
+							for _, r := range reports {
+								if r.Error != "" {
+									fmt.Printf("%s/%d: %s\n", r.Encoding, r.Level, r.Error)
+									continue
+								}
+								fmt.Printf("%s/%d: spearman %.4f, best accuracy %.4f at K %d, distance map %.2fs, query %.2fms\n",
+									r.Encoding, r.Level, r.Spearman, r.BestAccuracy, r.BestK, r.DistanceMapSeconds, r.QuerySeconds*1000)
+							}
+
+							outputFile, err := os.Create(outputFilePath)
+							if err != nil {
+								return err
+							}
+							if strings.HasSuffix(outputFilePath, ".json") {
+								encoder := json.NewEncoder(outputFile)
+								encoder.SetIndent("", "  ")
+								err = encoder.Encode(reports)
+							} else {
+								page := components.NewPage()
+								page.SetLayout(components.PageCenterLayout)
+								page.AddCharts(
+									eval.EncodingScoreChart(reports),
+									eval.EncodingAccuracyChart(reports),
+									eval.EncodingDistanceChart(reports),
+									eval.EncodingTimingChart(reports),
+								)
+								err = page.Render(outputFile)
+							}
+							if err != nil {
+								return err
+							}
+
+							return outputFile.Close()
+						},
+					},
 					{
 						Name: "histogram",
 						Action: func(ctx *cli.Context) error {
 							modelFilePath := ctx.Args().Get(0)
 							outputFilePath := ctx.Args().Get(1)
 
-							importedModel, err := model.LoadCompressionModelFromFile(modelFilePath)
+							importedModel, err := model.LoadModel(modelFilePath)
 							if err != nil {
 								return err
 							}
-							fmt.Printf("Loaded model with %d records.\n", len(importedModel.Items))
+							fmt.Printf("Loaded model with %d records.\n", importedModel.Len())
 							histogram, err := eval.CompressionSizeHistogram(importedModel)
 							if err != nil {
 								return err
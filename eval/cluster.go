@@ -0,0 +1,406 @@
+package eval
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+type LinkageType string
+
+const (
+	SingleLinkage   LinkageType = "single"
+	AverageLinkage  LinkageType = "average"
+	CompleteLinkage LinkageType = "complete"
+	WardLinkage     LinkageType = "ward"
+)
+
+func ToLinkageType(input string) (LinkageType, error) {
+	switch input {
+	case string(SingleLinkage):
+		return SingleLinkage, nil
+	case string(AverageLinkage):
+		return AverageLinkage, nil
+	case string(CompleteLinkage):
+		return CompleteLinkage, nil
+	case string(WardLinkage):
+		return WardLinkage, nil
+	}
+	return AverageLinkage, errors.New("invalid linkage type specified")
+}
+
+// Merge joins clusters A and B at Height. Clusters below the number of
+// items n are single items, cluster n+i is the one formed by merge i.
+type Merge struct {
+	A      int     `json:"a"`
+	B      int     `json:"b"`
+	Height float64 `json:"height"`
+	Size   int     `json:"size"`
+}
+
+// linkageDistance is the Lance-Williams update of the distance from
+// cluster k to clusters i and j once they are merged
+func linkageDistance(linkage LinkageType, dki, dkj, dij float64, ni, nj, nk int) float64 {
+	switch linkage {
+	case SingleLinkage:
+		return math.Min(dki, dkj)
+	case CompleteLinkage:
+		return math.Max(dki, dkj)
+	case WardLinkage:
+		total := float64(ni + nj + nk)
+		return math.Sqrt(math.Max(0, (float64(nk+ni)*dki*dki+float64(nk+nj)*dkj*dkj-float64(nk)*dij*dij)/total))
+	}
+	return (float64(ni)*dki + float64(nj)*dkj) / float64(ni+nj)
+}
+
+// Agglomerate repeatedly merges the two closest clusters, starting from
+// every item on its own, until one cluster is left. It follows chains of
+// nearest neighbours, so takes time quadratic in the number of items.
+func Agglomerate(distances [][]float64, linkage LinkageType) ([]Merge, error) {
+	n := len(distances)
+	if n < 2 {
+		return nil, errors.New("need at least 2 items to cluster")
+	}
+	d := make([][]float64, n)
+	for i := range d {
+		d[i] = append([]float64(nil), distances[i]...)
+	}
+	active := make([]bool, n)
+	sizes := make([]int, n)
+	for i := range active {
+		active[i] = true
+		sizes[i] = 1
+	}
+
+	// clusters are tracked by the slot of one of their items until the
+	// merges are put in order of height
+	type slotMerge struct {
+		a, b   int
+		height float64
+	}
+	slotMerges := make([]slotMerge, 0, n-1)
+	var chain []int
+	for len(slotMerges) < n-1 {
+		if len(chain) == 0 {
+			for i := range active {
+				if active[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+		var a, b int
+		for {
+			a = chain[len(chain)-1]
+			b = -1
+			min := math.Inf(1)
+			// stick with the previous link on ties, so the chain ends
+			if len(chain) > 1 {
+				b = chain[len(chain)-2]
+				min = d[a][b]
+			}
+			for c := range d[a] {
+				if c != a && active[c] && d[a][c] < min {
+					b, min = c, d[a][c]
+				}
+			}
+			if len(chain) > 1 && b == chain[len(chain)-2] {
+				break
+			}
+			chain = append(chain, b)
+		}
+		chain = chain[:len(chain)-2]
+
+		height := d[a][b]
+		slotMerges = append(slotMerges, slotMerge{a, b, height})
+		for k := range d {
+			if k == a || k == b || !active[k] {
+				continue
+			}
+			updated := linkageDistance(linkage, d[k][a], d[k][b], height, sizes[a], sizes[b], sizes[k])
+			d[k][a], d[a][k] = updated, updated
+		}
+		active[b] = false
+		sizes[a] += sizes[b]
+	}
+
+	sort.SliceStable(slotMerges, func(i, j int) bool {
+		return slotMerges[i].height < slotMerges[j].height
+	})
+	parents := make([]int, n)
+	ids := make([]int, n)
+	sizes = make([]int, n)
+	for i := range parents {
+		parents[i], ids[i], sizes[i] = i, i, 1
+	}
+	find := func(i int) int {
+		for parents[i] != i {
+			parents[i] = parents[parents[i]]
+			i = parents[i]
+		}
+		return i
+	}
+	merges := make([]Merge, len(slotMerges))
+	for i, sm := range slotMerges {
+		ra, rb := find(sm.a), find(sm.b)
+		merges[i] = Merge{
+			A:      ids[ra],
+			B:      ids[rb],
+			Height: sm.height,
+			Size:   sizes[ra] + sizes[rb],
+		}
+		parents[rb] = ra
+		ids[ra] = n + i
+		sizes[ra] += sizes[rb]
+	}
+	return merges, nil
+}
+
+// CutTree undoes the last k-1 merges to leave k clusters, and returns
+// which cluster each item is in. Clusters are numbered in order of their
+// first item.
+func CutTree(merges []Merge, k int) ([]int, error) {
+	n := len(merges) + 1
+	if k < 1 || k > n {
+		return nil, errors.New("number of clusters must be between 1 and the number of items")
+	}
+	parents := make([]int, 2*n-1)
+	for i := range parents {
+		parents[i] = i
+	}
+	for i, merge := range merges[:n-k] {
+		parents[merge.A] = n + i
+		parents[merge.B] = n + i
+	}
+	labels := make([]int, n)
+	roots := make(map[int]int)
+	for i := range labels {
+		root := i
+		for parents[root] != root {
+			root = parents[root]
+		}
+		if _, ok := roots[root]; !ok {
+			roots[root] = len(roots)
+		}
+		labels[i] = roots[root]
+	}
+	return labels, nil
+}
+
+// DendrogramChart draws the merges as a tree with items along the x axis
+// and the height clusters merged at up the y axis
+func DendrogramChart(merges []Merge) *charts.Line {
+	line := charts.NewLine()
+
+	n := len(merges) + 1
+	x := make([]float64, 2*n-1)
+	y := make([]float64, 2*n-1)
+	// leaves are placed left to right in the order a walk of the tree
+	// reaches them, so no branches cross
+	next := float64(0)
+	var place func(cluster int)
+	place = func(cluster int) {
+		if cluster < n {
+			x[cluster] = next
+			next++
+			return
+		}
+		merge := merges[cluster-n]
+		place(merge.A)
+		place(merge.B)
+		x[cluster] = (x[merge.A] + x[merge.B]) / 2
+		y[cluster] = merge.Height
+	}
+	place(2*n - 2)
+
+	data := make([]opts.LineData, 0, 5*len(merges))
+	for i, merge := range merges {
+		height := y[n+i]
+		data = append(data,
+			opts.LineData{Value: [2]float64{x[merge.A], y[merge.A]}},
+			opts.LineData{Value: [2]float64{x[merge.A], height}},
+			opts.LineData{Value: [2]float64{x[merge.B], height}},
+			opts.LineData{Value: [2]float64{x[merge.B], y[merge.B]}},
+			// breaks the line between merges
+			opts.LineData{Value: "-"},
+		)
+	}
+	line.AddSeries("Merges", data)
+
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Dendrogram",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Type: "value",
+			Max:  n - 1,
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: "Height",
+			Type: "value",
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{
+			Type: "slider",
+		}),
+	)
+
+	return line
+}
+
+type ClusterStats struct {
+	Cluster        int     `json:"cluster"`
+	Count          int     `json:"count"`
+	MeanResult     float64 `json:"mean_result"`
+	ResultVariance float64 `json:"result_variance"`
+	FirstTime      int64   `json:"first_time"`
+	MedianTime     int64   `json:"median_time"`
+	LastTime       int64   `json:"last_time"`
+}
+
+type ClusterAssignment struct {
+	Index     int   `json:"index"`
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	Cluster   int   `json:"cluster"`
+}
+
+type ClusterReport struct {
+	Linkage     LinkageType         `json:"linkage"`
+	Clusters    []ClusterStats      `json:"clusters"`
+	Assignments []ClusterAssignment `json:"assignments"`
+}
+
+// NewClusterReport summarises the outcomes and timing of each cluster of
+// items, which are given by their indexes in the model
+func NewClusterReport(m model.Model, indexes, labels []int, linkage LinkageType, horizonIndex int) *ClusterReport {
+	report := &ClusterReport{
+		Linkage: linkage,
+	}
+	results := make(map[int][]float64)
+	times := make(map[int][]int64)
+	for a, i := range indexes {
+		item := m.Item(i)
+		report.Assignments = append(report.Assignments, ClusterAssignment{
+			Index:     i,
+			StartTime: item.StartTime,
+			EndTime:   item.EndTime,
+			Cluster:   labels[a],
+		})
+		results[labels[a]] = append(results[labels[a]], item.ResultAt(horizonIndex))
+		times[labels[a]] = append(times[labels[a]], item.StartTime)
+	}
+
+	for cluster := 0; cluster < len(results); cluster++ {
+		stats := ClusterStats{
+			Cluster: cluster,
+			Count:   len(results[cluster]),
+		}
+		for _, r := range results[cluster] {
+			stats.MeanResult += r
+		}
+		stats.MeanResult /= float64(stats.Count)
+		for _, r := range results[cluster] {
+			stats.ResultVariance += math.Pow(r-stats.MeanResult, 2)
+		}
+		stats.ResultVariance /= float64(stats.Count)
+		clusterTimes := times[cluster]
+		sort.Slice(clusterTimes, func(i, j int) bool {
+			return clusterTimes[i] < clusterTimes[j]
+		})
+		stats.FirstTime = clusterTimes[0]
+		stats.MedianTime = clusterTimes[len(clusterTimes)/2]
+		stats.LastTime = clusterTimes[len(clusterTimes)-1]
+		report.Clusters = append(report.Clusters, stats)
+	}
+	return report
+}
+
+// WriteAssignmentsCSV writes each item's cluster, for joining onto market
+// data by time
+func (report *ClusterReport) WriteAssignmentsCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"index", "start_time", "end_time", "cluster"})
+	if err != nil {
+		return err
+	}
+	for _, a := range report.Assignments {
+		err = writer.Write([]string{
+			strconv.Itoa(a.Index),
+			strconv.FormatInt(a.StartTime, 10),
+			strconv.FormatInt(a.EndTime, 10),
+			strconv.Itoa(a.Cluster),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ClusterTimeChart stacks how many items of each cluster start in each of
+// buckets equal spans of time
+func ClusterTimeChart(report *ClusterReport, buckets int) (*charts.Bar, error) {
+	if buckets < 1 {
+		return nil, errors.New("number of buckets must be positive")
+	}
+	bar := charts.NewBar()
+
+	first, last := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, a := range report.Assignments {
+		if a.StartTime < first {
+			first = a.StartTime
+		}
+		if a.StartTime > last {
+			last = a.StartTime
+		}
+	}
+	span := (last-first)/int64(buckets) + 1
+	counts := make([][]int, len(report.Clusters))
+	for i := range counts {
+		counts[i] = make([]int, buckets)
+	}
+	for _, a := range report.Assignments {
+		counts[a.Cluster][(a.StartTime-first)/span]++
+	}
+
+	axis := make([]string, buckets)
+	for i := range axis {
+		axis[i] = time.UnixMilli(first + int64(i)*span).UTC().Format("2006-01-02")
+	}
+	bar.SetXAxis(axis)
+	for cluster, clusterCounts := range counts {
+		data := make([]opts.BarData, buckets)
+		for i, count := range clusterCounts {
+			data[i] = opts.BarData{Value: count}
+		}
+		bar.AddSeries(fmt.Sprintf("Cluster %d", cluster), data, charts.WithBarChartOpts(opts.BarChart{
+			Stack: "clusters",
+		}))
+	}
+
+	bar.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Clusters over Time",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return bar, nil
+}
@@ -0,0 +1,60 @@
+package eval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgglomerate(t *testing.T) {
+	positions := []float64{0, 1, 5, 6, 20}
+	distances := make([][]float64, len(positions))
+	for i := range positions {
+		distances[i] = make([]float64, len(positions))
+		for j := range positions {
+			distances[i][j] = math.Abs(positions[i] - positions[j])
+		}
+	}
+
+	for linkage, heights := range map[LinkageType][]float64{
+		SingleLinkage:   {1, 1, 4, 14},
+		CompleteLinkage: {1, 1, 6, 20},
+		AverageLinkage:  {1, 1, 5, 17},
+	} {
+		merges, err := Agglomerate(distances, linkage)
+		assert.NoError(t, err)
+		assert.Len(t, merges, 4)
+		for i, merge := range merges {
+			assert.Equal(t, heights[i], merge.Height, linkage)
+		}
+		assert.Equal(t, 5, merges[3].Size)
+
+		labels, err := CutTree(merges, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{0, 0, 1, 1, 2}, labels)
+	}
+
+	merges, err := Agglomerate(distances, WardLinkage)
+	assert.NoError(t, err)
+	labels, err := CutTree(merges, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 0, 0, 0, 1}, labels)
+
+	_, err = CutTree(merges, 6)
+	assert.Error(t, err)
+}
+
+func TestClusterTimeChart(t *testing.T) {
+	report := &ClusterReport{
+		Clusters: []ClusterStats{{Cluster: 0}, {Cluster: 1}},
+		Assignments: []ClusterAssignment{
+			{StartTime: 0, Cluster: 0},
+			{StartTime: 100, Cluster: 1},
+		},
+	}
+	_, err := ClusterTimeChart(report, 2)
+	assert.NoError(t, err)
+	_, err = ClusterTimeChart(report, 0)
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package eval
 
 import (
+	"fmt"
 	"math"
 	"sort"
 
@@ -17,7 +18,7 @@ func CompressionHeatMap(m model.Model, downSampleBy int) (*charts.HeatMap, error
 	if err != nil {
 		return nil, err
 	}
-	mapLength := len(similarityMap) / downSampleBy
+	mapLength := similarityMap.Len() / downSampleBy
 	hmData := make([]opts.HeatMapData, mapLength*mapLength)
 	inserted := 0
 	min := math.MaxFloat64
@@ -33,13 +34,20 @@ func CompressionHeatMap(m model.Model, downSampleBy int) (*charts.HeatMap, error
 					for y := 0; y < downSampleBy; y++ {
 						ix := (downSampleBy * i) + x
 						jy := (downSampleBy * j) + y
-						minLocalValue = math.Min(minLocalValue, similarityMap[ix][jy])
+						d, err := similarityMap.At(ix, jy)
+						if err != nil {
+							return nil, err
+						}
+						minLocalValue = math.Min(minLocalValue, d)
 					}
 				}
 				hmData[inserted] = opts.HeatMapData{Value: [3]interface{}{i, j, minLocalValue}}
 			} else {
-				minLocalValue = similarityMap[i][j]
-				hmData[inserted] = opts.HeatMapData{Value: [3]interface{}{i, j, similarityMap[i][j]}}
+				minLocalValue, err = similarityMap.At(i, j)
+				if err != nil {
+					return nil, err
+				}
+				hmData[inserted] = opts.HeatMapData{Value: [3]interface{}{i, j, minLocalValue}}
 			}
 
 			inserted += 1
@@ -85,41 +93,47 @@ func CompressionHeatMap(m model.Model, downSampleBy int) (*charts.HeatMap, error
 	return hmap, nil
 }
 
-func CompressionDstVarHistogram(m model.Model) (*charts.Bar, error) {
-	const bucketSize = float64(0.001)
-
+// DistanceVarianceChart plots the mean and variance of the difference
+// between the results of pairs of items in each distance bucket
+func DistanceVarianceChart(buckets []model.DistanceBucket) *charts.Bar {
 	bar := charts.NewBar()
 
-	buckets, err := model.DistanceVarianceHistogram(m, bucketSize)
-	if err != nil {
-		return nil, err
-	}
-
-	barData := make([]opts.BarData, len(buckets))
-	axis := make([]int, len(buckets))
-
-	i := 0
-	for bucket := range buckets {
-		axis[i] = bucket
-		i++
-	}
-	sort.Ints(axis)
-	for i, bucket := range axis {
-		barData[i] = opts.BarData{Value: buckets[bucket]}
+	axis := make([]string, len(buckets))
+	meanData := make([]opts.BarData, len(buckets))
+	varianceData := make([]opts.BarData, len(buckets))
+	for i, bucket := range buckets {
+		axis[i] = fmt.Sprintf("%.4f-%.4f (%d)", bucket.Lower, bucket.Upper, bucket.Count)
+		meanData[i] = opts.BarData{Value: bucket.Mean}
+		varianceData[i] = opts.BarData{Value: bucket.Variance}
 	}
 
-	bar.SetXAxis(axis).AddSeries("Distance", barData)
+	bar.SetXAxis(axis).
+		AddSeries("Mean result difference", meanData).
+		AddSeries("Result difference variance", varianceData)
 
 	bar.SetGlobalOptions(
 		charts.WithTitleOpts(opts.Title{
-			Title: "Compression Model Distance Result variance",
+			Title: "Result Difference by Distance",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Distance",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{
+			Type: "slider",
 		}),
 	)
 
-	return bar, nil
+	return bar
 }
 
-func CompressionSizeHistogram(model *model.CompressionModel) (*charts.Bar, error) {
+func CompressionSizeHistogram(model model.Model) (*charts.Bar, error) {
 	bar := charts.NewBar()
 
 	buckets := model.SizeResultBuckets()
@@ -0,0 +1,174 @@
+package eval
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+type PurgedKFoldOpts struct {
+	Folds int
+	// Embargo is how long after a test fold's last result training items
+	// are still dropped, to cover serial correlation past the horizon
+	Embargo int64
+}
+
+type CVFold struct {
+	// Train and Test are indexes of the model's items
+	Train []int
+	Test  []int
+	// Purged counts training items dropped for overlapping the test
+	// fold, and Embargoed those dropped for starting within the embargo
+	Purged    int
+	Embargoed int
+}
+
+// PurgedKFold splits a model's items into folds of consecutive time, each
+// fold is tested against the items from every other fold that share no
+// data or results with it
+func PurgedKFold(m model.Model, opts PurgedKFoldOpts) ([]CVFold, error) {
+	n := m.Len()
+	if opts.Folds < 2 || opts.Folds > n {
+		return nil, errors.New("fold count must be between 2 and the number of items")
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+		if m.Item(i).ResultTime == 0 {
+			return nil, errors.New("model items have no timestamps, rebuild the model")
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return m.Item(order[a]).StartTime < m.Item(order[b]).StartTime
+	})
+
+	folds := make([]CVFold, opts.Folds)
+	for f := range folds {
+		test := order[f*n/opts.Folds : (f+1)*n/opts.Folds]
+		// the fold's test items are contiguous in time, so they can be
+		// treated as a single item spanning all of them
+		span := model.Item{
+			StartTime:  m.Item(test[0]).StartTime,
+			ResultTime: m.Item(test[0]).ResultTime,
+		}
+		for _, i := range test {
+			if rt := m.Item(i).ResultTime; rt > span.ResultTime {
+				span.ResultTime = rt
+			}
+		}
+		// the embargo only follows the fold
+		embargoed := span
+		embargoed.ResultTime += opts.Embargo
+
+		fold := CVFold{Test: test}
+		for a, i := range order {
+			if a >= f*n/opts.Folds && a < (f+1)*n/opts.Folds {
+				continue
+			}
+			item := m.Item(i)
+			if item.Overlaps(span, 0) {
+				fold.Purged++
+				continue
+			}
+			if item.Overlaps(embargoed, 0) {
+				fold.Embargoed++
+				continue
+			}
+			fold.Train = append(fold.Train, i)
+		}
+		folds[f] = fold
+	}
+	return folds, nil
+}
+
+type CVFoldReport struct {
+	Fold       int     `json:"fold"`
+	TestStart  int64   `json:"test_start"`
+	TestEnd    int64   `json:"test_end"`
+	TrainItems int     `json:"train_items"`
+	Purged     int     `json:"purged"`
+	Embargoed  int     `json:"embargoed"`
+	Metrics    Metrics `json:"metrics"`
+}
+
+type CVReport struct {
+	Folds   []CVFoldReport `json:"folds"`
+	Overall Metrics        `json:"overall"`
+}
+
+// CrossValidate predicts every item from its nearest neighbours in the
+// training items of its purged fold, using the model's distance map
+func CrossValidate(m model.Model, opts PurgedKFoldOpts, predictOpts model.PredictionOpts) (*CVReport, error) {
+	if predictOpts.NearestN < 1 {
+		return nil, errors.New("number of neighbours must be positive")
+	}
+	folds, err := PurgedKFold(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	distanceMap, err := m.DistanceMap()
+	if err != nil {
+		return nil, err
+	}
+	horizonIndex, err := m.Options().HorizonIndex(predictOpts.Horizon)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CVReport{}
+	var all []PredictionRecord
+	var row []float64
+	var neighbours []int
+	var distances []float64
+	inTrain := make([]bool, m.Len())
+	for f, fold := range folds {
+		for i := range inTrain {
+			inTrain[i] = false
+		}
+		for _, i := range fold.Train {
+			inTrain[i] = true
+		}
+		exclude := func(j int) bool {
+			return !inTrain[j]
+		}
+
+		records := make([]PredictionRecord, 0, len(fold.Test))
+		for _, i := range fold.Test {
+			neighbours, distances, row, err = nearestInMatrix(distanceMap, i, predictOpts.NearestN, row, exclude)
+			if err != nil {
+				return nil, err
+			}
+			results := make([]float64, len(neighbours))
+			for n, j := range neighbours {
+				results[n] = m.Item(j).ResultAt(horizonIndex)
+			}
+			prediction, err := model.PredictFromResults(distances, results, predictOpts)
+			if err != nil {
+				return nil, err
+			}
+			item := m.Item(i)
+			actual := item.ResultAt(horizonIndex)
+			records = append(records, PredictionRecord{
+				Fold:        f,
+				StartTime:   item.StartTime,
+				EndTime:     item.EndTime,
+				Actual:      actual,
+				ActualClass: predictOpts.Classify(actual),
+				Prediction:  prediction,
+			})
+		}
+		all = append(all, records...)
+		report.Folds = append(report.Folds, CVFoldReport{
+			Fold:       f,
+			TestStart:  m.Item(fold.Test[0]).StartTime,
+			TestEnd:    m.Item(fold.Test[len(fold.Test)-1]).EndTime,
+			TrainItems: len(fold.Train),
+			Purged:     fold.Purged,
+			Embargoed:  fold.Embargoed,
+			Metrics:    ComputeMetrics(records),
+		})
+	}
+	report.Overall = ComputeMetrics(all)
+	return report, nil
+}
@@ -0,0 +1,66 @@
+package eval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestPurgedKFold(t *testing.T) {
+	const hour = int64(60 * 60 * 1000)
+	data := make([]record.Market, 200)
+	for i := range data {
+		price := 100 + math.Sin(float64(i)/5)
+		data[i] = record.Market{
+			Timestamp: int64(i) * hour,
+			Open:      price,
+			High:      price + 1,
+			Low:       price - 1,
+			Close:     price,
+			Volume:    1000,
+			VWAP:      price,
+		}
+	}
+	m := model.NewCosineModel(splicer.SpliceOptions{
+		Period:  10,
+		ResultN: 5,
+	})
+	assert.NoError(t, m.AddMarketData(data))
+
+	folds, err := PurgedKFold(m, PurgedKFoldOpts{Folds: 4, Embargo: 3 * hour})
+	assert.NoError(t, err)
+	assert.Len(t, folds, 4)
+
+	tested := 0
+	for f, fold := range folds {
+		tested += len(fold.Test)
+		assert.Equal(t, m.Len(), len(fold.Train)+len(fold.Test)+fold.Purged+fold.Embargoed)
+		for _, i := range fold.Train {
+			for _, j := range fold.Test {
+				embargoed := m.Item(j)
+				embargoed.ResultTime += 3 * hour
+				assert.False(t, m.Item(i).Overlaps(embargoed, 0))
+			}
+		}
+		// each item spans 15 bars, so 14 are purged either side of a fold
+		// and the embargo drops 3 more after it
+		purged, embargoed := 28, 3
+		if f == 0 {
+			purged = 14
+		}
+		if f == len(folds)-1 {
+			purged, embargoed = 14, 0
+		}
+		assert.Equal(t, purged, fold.Purged)
+		assert.Equal(t, embargoed, fold.Embargoed)
+	}
+	assert.Equal(t, m.Len(), tested)
+
+	_, err = CrossValidate(m, PurgedKFoldOpts{Folds: 4}, model.PredictionOpts{Strategy: model.DiscreteWNN})
+	assert.Error(t, err)
+}
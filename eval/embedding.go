@@ -0,0 +1,374 @@
+package eval
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+type EmbeddingType string
+
+const (
+	MDSEmbedding  EmbeddingType = "mds"
+	TSNEEmbedding EmbeddingType = "tsne"
+)
+
+func ToEmbeddingType(input string) (EmbeddingType, error) {
+	switch input {
+	case string(MDSEmbedding):
+		return MDSEmbedding, nil
+	case string(TSNEEmbedding):
+		return TSNEEmbedding, nil
+	}
+	return MDSEmbedding, errors.New("invalid embedding type specified")
+}
+
+type TSNEOpts struct {
+	Perplexity float64
+	Iterations int
+	// LearningRate defaults to the number of items over 48, but at least 50
+	LearningRate float64
+	Seed         int64
+}
+
+// SampleDistances copies the distances between every step-th item of the
+// model's distance map into a square matrix, and returns which items they
+// were
+func SampleDistances(m model.Model, step int) ([][]float64, []int, error) {
+	if step < 1 {
+		return nil, nil, errors.New("step must be at least 1")
+	}
+	matrix, err := m.DistanceMap()
+	if err != nil {
+		return nil, nil, err
+	}
+	var indexes []int
+	for i := 0; i < matrix.Len(); i += step {
+		indexes = append(indexes, i)
+	}
+	distances := make([][]float64, len(indexes))
+	var row []float64
+	for a, i := range indexes {
+		row, err = matrix.Row(i, row)
+		if err != nil {
+			return nil, nil, err
+		}
+		distances[a] = make([]float64, len(indexes))
+		for b, j := range indexes {
+			distances[a][b] = row[j]
+		}
+	}
+	return distances, indexes, nil
+}
+
+// ClassicalMDS places items in two dimensions so the euclidean distances
+// between them best match distances, by taking the top two eigenvectors of
+// the double centred squared distance matrix
+func ClassicalMDS(distances [][]float64) ([][2]float64, error) {
+	n := len(distances)
+	if n < 3 {
+		return nil, errors.New("need at least 3 items to embed")
+	}
+	b := make([][]float64, n)
+	rowMeans := make([]float64, n)
+	totalMean := float64(0)
+	for i := range b {
+		b[i] = make([]float64, n)
+		for j := range b[i] {
+			b[i][j] = distances[i][j] * distances[i][j]
+			rowMeans[i] += b[i][j]
+		}
+		totalMean += rowMeans[i]
+		rowMeans[i] /= float64(n)
+	}
+	totalMean /= float64(n * n)
+	for i := range b {
+		for j := range b[i] {
+			b[i][j] = -0.5 * (b[i][j] - rowMeans[i] - rowMeans[j] + totalMean)
+		}
+	}
+
+	// distances that aren't euclidean, like NCD, leave negative eigenvalues
+	// which are deflated and skipped
+	points := make([][2]float64, n)
+	rng := rand.New(rand.NewSource(1))
+	found := 0
+	for attempt := 0; found < 2 && attempt < 10; attempt++ {
+		value, vector := powerIteration(b, rng)
+		for i := range b {
+			for j := range b[i] {
+				b[i][j] -= value * vector[i] * vector[j]
+			}
+		}
+		if value <= 0 {
+			continue
+		}
+		scale := math.Sqrt(value)
+		for i := range points {
+			points[i][found] = vector[i] * scale
+		}
+		found++
+	}
+	if found < 2 {
+		return nil, errors.New("distances have fewer than two positive dimensions")
+	}
+	return points, nil
+}
+
+// powerIteration returns the eigenvalue of largest magnitude of the
+// symmetric matrix m and its unit eigenvector
+func powerIteration(m [][]float64, rng *rand.Rand) (float64, []float64) {
+	vector := make([]float64, len(m))
+	for i := range vector {
+		vector[i] = rng.Float64() - 0.5
+	}
+	next := make([]float64, len(m))
+	value := float64(0)
+	for iter := 0; iter < 1000; iter++ {
+		norm := float64(0)
+		for i := range m {
+			next[i] = 0
+			for j, v := range m[i] {
+				next[i] += v * vector[j]
+			}
+			norm += next[i] * next[i]
+		}
+		norm = math.Sqrt(norm)
+		if norm == 0 {
+			return 0, vector
+		}
+		// the rayleigh quotient keeps the sign of the eigenvalue
+		nextValue := float64(0)
+		for i := range next {
+			nextValue += vector[i] * next[i]
+			next[i] /= norm
+		}
+		vector, next = next, vector
+		if math.Abs(nextValue-value) <= 1e-9*math.Abs(nextValue) {
+			return nextValue, vector
+		}
+		value = nextValue
+	}
+	return value, vector
+}
+
+// TSNE places items in two dimensions so that items close in distances
+// stay close, at the cost of distances between far apart items meaning
+// little. It is exact, so takes time quadratic in the number of items.
+func TSNE(distances [][]float64, tsneOpts TSNEOpts) ([][2]float64, error) {
+	n := len(distances)
+	if n < 3 {
+		return nil, errors.New("need at least 3 items to embed")
+	}
+	if tsneOpts.Perplexity <= 0 || tsneOpts.Perplexity >= float64(n-1) {
+		return nil, errors.New("perplexity must be positive and less than the number of items")
+	}
+	p := tsneAffinities(distances, tsneOpts.Perplexity)
+	learningRate := tsneOpts.LearningRate
+	if learningRate <= 0 {
+		learningRate = math.Max(float64(n)/48, 50)
+	}
+
+	rng := rand.New(rand.NewSource(tsneOpts.Seed))
+	points := make([][2]float64, n)
+	for i := range points {
+		points[i] = [2]float64{rng.NormFloat64() * 1e-4, rng.NormFloat64() * 1e-4}
+	}
+	updates := make([][2]float64, n)
+	gains := make([][2]float64, n)
+	for i := range gains {
+		gains[i] = [2]float64{1, 1}
+	}
+	gradients := make([][2]float64, n)
+	num := make([][]float64, n)
+	for i := range num {
+		num[i] = make([]float64, n)
+	}
+
+	// exaggerating affinities early on lets clusters form before they are
+	// pulled into place
+	exaggerationEnd := tsneOpts.Iterations / 4
+	if exaggerationEnd > 250 {
+		exaggerationEnd = 250
+	}
+	for iter := 0; iter < tsneOpts.Iterations; iter++ {
+		exaggeration, momentum := float64(1), 0.8
+		if iter < exaggerationEnd {
+			exaggeration, momentum = 12, 0.5
+		}
+
+		total := float64(0)
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				dx, dy := points[i][0]-points[j][0], points[i][1]-points[j][1]
+				q := 1 / (1 + dx*dx + dy*dy)
+				num[i][j], num[j][i] = q, q
+				total += 2 * q
+			}
+		}
+		for i := 0; i < n; i++ {
+			gradients[i] = [2]float64{}
+			for j := 0; j < n; j++ {
+				if i == j {
+					continue
+				}
+				force := 4 * (exaggeration*p[i][j] - num[i][j]/total) * num[i][j]
+				gradients[i][0] += force * (points[i][0] - points[j][0])
+				gradients[i][1] += force * (points[i][1] - points[j][1])
+			}
+		}
+
+		mean := [2]float64{}
+		for i := range points {
+			for d := 0; d < 2; d++ {
+				if (gradients[i][d] > 0) != (updates[i][d] > 0) {
+					gains[i][d] += 0.2
+				} else {
+					gains[i][d] = math.Max(gains[i][d]*0.8, 0.01)
+				}
+				updates[i][d] = momentum*updates[i][d] - learningRate*gains[i][d]*gradients[i][d]
+				points[i][d] += updates[i][d]
+				mean[d] += points[i][d]
+			}
+		}
+		for i := range points {
+			points[i][0] -= mean[0] / float64(n)
+			points[i][1] -= mean[1] / float64(n)
+		}
+	}
+	return points, nil
+}
+
+// tsneAffinities returns the symmetric joint probabilities of items being
+// neighbours, with a gaussian kernel around each item sized so its
+// neighbourhood has the given perplexity
+func tsneAffinities(distances [][]float64, perplexity float64) [][]float64 {
+	n := len(distances)
+	target := math.Log(perplexity)
+	conditional := make([][]float64, n)
+	for i := range conditional {
+		conditional[i] = make([]float64, n)
+		beta, lower, upper := float64(1), float64(0), math.Inf(1)
+		for step := 0; step < 50; step++ {
+			total, weighted := float64(0), float64(0)
+			for j, d := range distances[i] {
+				if j == i {
+					continue
+				}
+				conditional[i][j] = math.Exp(-beta * d * d)
+				total += conditional[i][j]
+				weighted += d * d * conditional[i][j]
+			}
+			if total == 0 {
+				// every neighbour is too far for beta, so widen the kernel
+				upper = beta
+				beta = (lower + beta) / 2
+				continue
+			}
+			entropy := math.Log(total) + beta*weighted/total
+			for j := range conditional[i] {
+				conditional[i][j] /= total
+			}
+			if math.Abs(entropy-target) < 1e-5 {
+				break
+			}
+			if entropy > target {
+				lower = beta
+				if math.IsInf(upper, 1) {
+					beta *= 2
+				} else {
+					beta = (beta + upper) / 2
+				}
+			} else {
+				upper = beta
+				beta = (beta + lower) / 2
+			}
+		}
+	}
+
+	p := make([][]float64, n)
+	for i := range p {
+		p[i] = make([]float64, n)
+		for j := range p[i] {
+			p[i][j] = math.Max((conditional[i][j]+conditional[j][i])/float64(2*n), 1e-12)
+		}
+	}
+	return p
+}
+
+func embeddingChart(title, valueName string, points [][2]float64, values []float64) *charts.Scatter {
+	scatter := charts.NewScatter()
+
+	min, max := math.Inf(1), math.Inf(-1)
+	data := make([]opts.ScatterData, len(points))
+	for i, point := range points {
+		data[i] = opts.ScatterData{
+			Value:      [3]float64{point[0], point[1], values[i]},
+			SymbolSize: 5,
+		}
+		min = math.Min(min, values[i])
+		max = math.Max(max, values[i])
+	}
+	scatter.AddSeries("Items", data)
+
+	scatter.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: title,
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Type: "value",
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Type: "value",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{
+			Type:       "inside",
+			XAxisIndex: 0,
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{
+			Type:       "inside",
+			YAxisIndex: 0,
+		}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Calculable: true,
+			Dimension:  "2",
+			Min:        float32(min),
+			Max:        float32(max),
+			Text:       []string{valueName},
+			InRange: &opts.VisualMapInRange{
+				Color: []string{"#50a3ba", "#eac736", "#d94e5d"},
+			},
+		}),
+	)
+
+	return scatter
+}
+
+// EmbeddingCharts plot embedded items, given by their indexes in the
+// model, coloured by their result at horizonIndex and by how many days
+// after the first item they start
+func EmbeddingCharts(m model.Model, points [][2]float64, indexes []int, horizonIndex int) (*charts.Scatter, *charts.Scatter) {
+	results := make([]float64, len(indexes))
+	days := make([]float64, len(indexes))
+	first := int64(math.MaxInt64)
+	for _, i := range indexes {
+		if start := m.Item(i).StartTime; start < first {
+			first = start
+		}
+	}
+	for a, i := range indexes {
+		item := m.Item(i)
+		results[a] = item.ResultAt(horizonIndex)
+		days[a] = float64(item.StartTime-first) / float64(24*60*60*1000)
+	}
+	return embeddingChart("Embedding by Result", "Result", points, results),
+		embeddingChart("Embedding by Time", "Days", points, days)
+}
@@ -0,0 +1,67 @@
+package eval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func euclidean(a, b [2]float64) float64 {
+	return math.Hypot(a[0]-b[0], a[1]-b[1])
+}
+
+func pairwise(points [][2]float64) [][]float64 {
+	distances := make([][]float64, len(points))
+	for i := range points {
+		distances[i] = make([]float64, len(points))
+		for j := range points {
+			distances[i][j] = euclidean(points[i], points[j])
+		}
+	}
+	return distances
+}
+
+func TestClassicalMDS(t *testing.T) {
+	original := [][2]float64{{0, 0}, {3, 0}, {0, 4}, {5, 1}, {2, 2}}
+	distances := pairwise(original)
+
+	points, err := ClassicalMDS(distances)
+	assert.NoError(t, err)
+	// points on a plane are recovered up to rotation and reflection
+	for i := range points {
+		for j := range points {
+			assert.InDelta(t, distances[i][j], euclidean(points[i], points[j]), 1e-6)
+		}
+	}
+}
+
+func TestTSNE(t *testing.T) {
+	var original [][2]float64
+	for i := 0; i < 50; i++ {
+		original = append(original, [2]float64{float64(i % 5), float64(i / 5)})
+		original = append(original, [2]float64{100 + float64(i%5), float64(i / 5)})
+	}
+
+	points, err := TSNE(pairwise(original), TSNEOpts{
+		Perplexity: 10,
+		Iterations: 300,
+		Seed:       1,
+	})
+	assert.NoError(t, err)
+	// every point is closer to the rest of its cluster than to the other
+	for i := range points {
+		nearestOther, furthestSame := math.Inf(1), float64(0)
+		for j := range points {
+			if i == j {
+				continue
+			}
+			if i%2 == j%2 {
+				furthestSame = math.Max(furthestSame, euclidean(points[i], points[j]))
+			} else {
+				nearestOther = math.Min(nearestOther, euclidean(points[i], points[j]))
+			}
+		}
+		assert.Less(t, furthestSame, nearestOther)
+	}
+}
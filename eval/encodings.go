@@ -0,0 +1,364 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+type EncodingsOpts struct {
+	SpliceOptions splicer.SpliceOptions
+	Combine       record.CombineStrategy
+	Encodings     []model.CompressionEncodingType
+	// Levels are the libdeflate compression levels to try each encoding at
+	Levels []int
+	// MaxK is the largest number of neighbours leave one out accuracy is
+	// measured for
+	MaxK          int
+	OverlapWindow int64
+	Prediction    model.PredictionOpts
+	// Queries is how many items are looked up again as new observations to
+	// time queries
+	Queries int
+}
+
+type DistanceStats struct {
+	Min    float64 `json:"min"`
+	Q1     float64 `json:"q1"`
+	Median float64 `json:"median"`
+	Q3     float64 `json:"q3"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Std    float64 `json:"std"`
+}
+
+type EncodingReport struct {
+	Encoding model.CompressionEncodingType `json:"encoding"`
+	Level    int                           `json:"compression_level"`
+	Items    int                           `json:"items"`
+	// Distances and Spearman only count pairs of items that don't overlap
+	Distances DistanceStats `json:"distances"`
+	// Spearman is the rank correlation between the distance between pairs
+	// and the difference between their results, it is positive when closer
+	// pairs have more similar outcomes
+	Spearman           float64       `json:"spearman"`
+	LOO                []LOOAccuracy `json:"loo"`
+	BestK              int           `json:"best_k"`
+	BestAccuracy       float64       `json:"best_accuracy"`
+	BuildSeconds       float64       `json:"build_seconds"`
+	DistanceMapSeconds float64       `json:"distance_map_seconds"`
+	// QuerySeconds is the mean time to find an observation's neighbours
+	QuerySeconds float64 `json:"query_seconds"`
+	Error        string  `json:"error,omitempty"`
+}
+
+func (r EncodingReport) name() string {
+	return fmt.Sprintf("%s/%d", r.Encoding, r.Level)
+}
+
+// ranks returns the rank of each value, tied values share the mean of
+// their ranks
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return values[order[a]] < values[order[b]]
+	})
+	res := make([]float64, len(values))
+	for from := 0; from < len(order); {
+		to := from + 1
+		for to < len(order) && values[order[to]] == values[order[from]] {
+			to++
+		}
+		rank := float64(from+to-1) / 2
+		for _, i := range order[from:to] {
+			res[i] = rank
+		}
+		from = to
+	}
+	return res
+}
+
+func spearman(xs, ys []float64) float64 {
+	return pearson(ranks(xs), ranks(ys))
+}
+
+func distanceStats(distances []float64) DistanceStats {
+	sorted := append([]float64(nil), distances...)
+	sort.Float64s(sorted)
+	quantile := func(q float64) float64 {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	stats := DistanceStats{
+		Min:    sorted[0],
+		Q1:     quantile(0.25),
+		Median: quantile(0.5),
+		Q3:     quantile(0.75),
+		Max:    sorted[len(sorted)-1],
+	}
+	for _, d := range sorted {
+		stats.Mean += d
+	}
+	stats.Mean /= float64(len(sorted))
+	for _, d := range sorted {
+		stats.Std += math.Pow(d-stats.Mean, 2)
+	}
+	stats.Std = math.Sqrt(stats.Std / float64(len(sorted)))
+	return stats
+}
+
+func evaluateEncoding(data []record.Market, m *model.CompressionModel, opts EncodingsOpts, report *EncodingReport) error {
+	start := time.Now()
+	err := m.AddMarketData(data)
+	if err != nil {
+		return err
+	}
+	report.BuildSeconds = time.Since(start).Seconds()
+	report.Items = m.Len()
+	if m.Len() < 2 {
+		return errors.New("not enough data for a pair of items")
+	}
+
+	start = time.Now()
+	distanceMap, err := m.DistanceMap()
+	if err != nil {
+		return err
+	}
+	defer m.DiscardDistanceMap()
+	report.DistanceMapSeconds = time.Since(start).Seconds()
+
+	horizonIndex, err := m.Options().HorizonIndex(opts.Prediction.Horizon)
+	if err != nil {
+		return err
+	}
+	var distances, differences []float64
+	var row []float64
+	for i := 0; i < m.Len(); i++ {
+		row, err = distanceMap.Row(i, row)
+		if err != nil {
+			return err
+		}
+		item := m.Item(i)
+		for j := i + 1; j < m.Len(); j++ {
+			other := m.Item(j)
+			if item.Overlaps(other, opts.OverlapWindow) {
+				continue
+			}
+			distances = append(distances, row[j])
+			differences = append(differences, math.Abs(item.ResultAt(horizonIndex)-other.ResultAt(horizonIndex)))
+		}
+	}
+	if len(distances) == 0 {
+		return errors.New("every pair of items overlaps")
+	}
+	report.Distances = distanceStats(distances)
+	report.Spearman = spearman(distances, differences)
+
+	report.LOO, err = LeaveOneOutAccuracy(m, opts.MaxK, opts.OverlapWindow, opts.Prediction)
+	if err != nil {
+		return err
+	}
+	for _, a := range report.LOO {
+		if a.Accuracy > report.BestAccuracy {
+			report.BestK, report.BestAccuracy = a.K, a.Accuracy
+		}
+	}
+
+	queries := opts.Queries
+	if queries > m.Len() {
+		queries = m.Len()
+	}
+	if queries > 0 {
+		start = time.Now()
+		for q := 0; q < queries; q++ {
+			_, err = m.Neighbours(m.Item(q*m.Len()/queries).Data, opts.MaxK)
+			if err != nil {
+				return err
+			}
+		}
+		report.QuerySeconds = time.Since(start).Seconds() / float64(queries)
+	}
+	return nil
+}
+
+// CompareEncodings builds a compression model from data under every
+// combination of encoding and compression level, and measures how well the
+// distances of each separate items by outcome and how long they take
+func CompareEncodings(data []record.Market, opts EncodingsOpts, progress model.ProgressFunc) ([]EncodingReport, error) {
+	if opts.MaxK < 1 {
+		return nil, errors.New("number of neighbours must be positive")
+	}
+	var reports []EncodingReport
+	total := len(opts.Encodings) * len(opts.Levels)
+	for _, encoding := range opts.Encodings {
+		for _, level := range opts.Levels {
+			report := EncodingReport{
+				Encoding: encoding,
+				Level:    level,
+			}
+			m := model.NewCompressionModel(opts.SpliceOptions, encoding, opts.Combine)
+			m.CompressionLevel = level
+			if err := evaluateEncoding(data, m, opts, &report); err != nil {
+				report.Error = err.Error()
+			}
+			reports = append(reports, report)
+			if progress != nil {
+				progress(len(reports), total)
+			}
+		}
+	}
+	return reports, nil
+}
+
+// EncodingAccuracyChart plots the leave one out accuracy by K of every
+// encoding
+func EncodingAccuracyChart(reports []EncodingReport) *charts.Line {
+	line := charts.NewLine()
+
+	maxK := 0
+	for _, r := range reports {
+		if len(r.LOO) > maxK {
+			maxK = len(r.LOO)
+		}
+	}
+	axis := make([]int, maxK)
+	for i := range axis {
+		axis[i] = i + 1
+	}
+	line.SetXAxis(axis)
+	for _, r := range reports {
+		data := make([]opts.LineData, len(r.LOO))
+		for i, a := range r.LOO {
+			data[i] = opts.LineData{Value: a.Accuracy}
+		}
+		line.AddSeries(r.name(), data)
+	}
+
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Leave One Out Accuracy by Encoding",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "K",
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Scale: true,
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+			Type: "scroll",
+		}),
+	)
+
+	return line
+}
+
+// EncodingDistanceChart plots the spread of distances between pairs of
+// items under every encoding
+func EncodingDistanceChart(reports []EncodingReport) *charts.BoxPlot {
+	box := charts.NewBoxPlot()
+
+	axis := make([]string, len(reports))
+	data := make([]opts.BoxPlotData, len(reports))
+	for i, r := range reports {
+		axis[i] = r.name()
+		d := r.Distances
+		data[i] = opts.BoxPlotData{Value: []float64{d.Min, d.Q1, d.Median, d.Q3, d.Max}}
+	}
+	box.SetXAxis(axis).AddSeries("Distance", data)
+
+	box.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Pairwise Distances by Encoding",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+	)
+
+	return box
+}
+
+// EncodingScoreChart compares the best leave one out accuracy and the
+// rank correlation of distance with result difference of every encoding
+func EncodingScoreChart(reports []EncodingReport) *charts.Bar {
+	bar := charts.NewBar()
+
+	axis := make([]string, len(reports))
+	accuracyData := make([]opts.BarData, len(reports))
+	spearmanData := make([]opts.BarData, len(reports))
+	for i, r := range reports {
+		axis[i] = r.name()
+		accuracyData[i] = opts.BarData{Value: r.BestAccuracy}
+		spearmanData[i] = opts.BarData{Value: r.Spearman}
+	}
+	bar.SetXAxis(axis).
+		AddSeries("Best accuracy", accuracyData).
+		AddSeries("Spearman", spearmanData)
+
+	bar.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Encoding Scores",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return bar
+}
+
+// EncodingTimingChart compares how long every encoding took to build, to
+// compute the distance map and to query
+func EncodingTimingChart(reports []EncodingReport) *charts.Bar {
+	bar := charts.NewBar()
+
+	axis := make([]string, len(reports))
+	buildData := make([]opts.BarData, len(reports))
+	distanceMapData := make([]opts.BarData, len(reports))
+	queryData := make([]opts.BarData, len(reports))
+	for i, r := range reports {
+		axis[i] = r.name()
+		buildData[i] = opts.BarData{Value: r.BuildSeconds}
+		distanceMapData[i] = opts.BarData{Value: r.DistanceMapSeconds}
+		queryData[i] = opts.BarData{Value: r.QuerySeconds * 1000}
+	}
+	bar.SetXAxis(axis).
+		AddSeries("Build (s)", buildData).
+		AddSeries("Distance map (s)", distanceMapData).
+		AddSeries("Query (ms)", queryData)
+
+	bar.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Encoding Timings",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return bar
+}
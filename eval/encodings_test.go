@@ -0,0 +1,78 @@
+package eval
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestSpearman(t *testing.T) {
+	assert.Equal(t, []float64{1.5, 0, 1.5, 3}, ranks([]float64{2, 1, 2, 5}))
+	assert.InDelta(t, 1, spearman([]float64{1, 2, 3, 4}, []float64{1, 4, 9, 16}), 1e-9)
+	assert.InDelta(t, -1, spearman([]float64{1, 2, 3, 4}, []float64{8, 4, 2, 1}), 1e-9)
+}
+
+func TestCompareEncodings(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]record.Market, 100)
+	for i := range data {
+		data[i] = record.Market{
+			Timestamp: int64(i),
+			Open:      rng.Float64(),
+			High:      rng.Float64(),
+			Low:       rng.Float64(),
+			Close:     rng.Float64(),
+			Volume:    rng.Float64(),
+			VWAP:      rng.Float64(),
+		}
+	}
+	opts := EncodingsOpts{
+		SpliceOptions: splicer.SpliceOptions{
+			Period:            8,
+			ResultN:           2,
+			SkipN:             1,
+			NormalisationType: record.ZScore,
+		},
+		Combine:   record.InterleaveCombine,
+		Encodings: []model.CompressionEncodingType{model.SimpleEncoding, model.RomanEncoding},
+		Levels:    []int{1, 12},
+		MaxK:      3,
+		Prediction: model.PredictionOpts{
+			Strategy: model.DiscreteWNN,
+		},
+		Queries: 2,
+	}
+	reports, err := CompareEncodings(data, opts, nil)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 4)
+	for _, r := range reports {
+		assert.Empty(t, r.Error, r.name())
+		assert.Len(t, r.LOO, 3)
+		assert.LessOrEqual(t, r.Distances.Min, r.Distances.Median)
+		assert.LessOrEqual(t, r.Distances.Median, r.Distances.Max)
+		assert.Greater(t, r.QuerySeconds, float64(0))
+	}
+	assert.Equal(t, 12, reports[1].Level)
+	assert.NotEqual(t, reports[0].Distances, reports[1].Distances)
+
+	// higher levels search harder for matches, so compress smaller
+	sizes := make([]int, len(opts.Levels))
+	for i, level := range opts.Levels {
+		m := model.NewCompressionModel(opts.SpliceOptions, model.RomanEncoding, opts.Combine)
+		m.CompressionLevel = level
+		assert.NoError(t, m.AddMarketData(data))
+		for _, item := range m.Items {
+			sizes[i] += item.CompressedSize
+		}
+	}
+	assert.Less(t, sizes[1], sizes[0])
+
+	opts.MaxK = 0
+	_, err = CompareEncodings(data, opts, nil)
+	assert.Error(t, err)
+}
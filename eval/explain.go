@@ -0,0 +1,152 @@
+package eval
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+type ExplainedNeighbour struct {
+	Index     int     `json:"index"`
+	StartTime int64   `json:"start_time"`
+	EndTime   int64   `json:"end_time"`
+	Distance  float64 `json:"distance"`
+	Result    float64 `json:"result"`
+	Class     string  `json:"class"`
+	// Closes are normalised the same way as the observation's
+	Closes []float64 `json:"-"`
+}
+
+// Explanation is a prediction along with the neighbours it was made from
+type Explanation struct {
+	StartTime  int64                `json:"start_time"`
+	EndTime    int64                `json:"end_time"`
+	Prediction *model.Prediction    `json:"prediction"`
+	Neighbours []ExplainedNeighbour `json:"neighbours"`
+	Closes     []float64            `json:"-"`
+}
+
+// Explain predicts from the window of data ending at its last bar, and
+// keeps the neighbours the prediction came from
+func Explain(m model.Model, data []record.Market, predictOpts model.PredictionOpts) (*Explanation, error) {
+	observation, err := splicer.Observation(data, m.Options())
+	if err != nil {
+		return nil, err
+	}
+	neighbours, err := m.Neighbours(observation, predictOpts.NearestN)
+	if err != nil {
+		return nil, err
+	}
+	prediction, err := model.PredictFromNeighbours(neighbours, m.Options(), predictOpts)
+	if err != nil {
+		return nil, err
+	}
+	horizonIndex, err := m.Options().HorizonIndex(predictOpts.Horizon)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{
+		StartTime:  data[len(data)-m.Options().Period].Timestamp,
+		EndTime:    data[len(data)-1].Timestamp,
+		Prediction: prediction,
+		Closes:     observation.Closes,
+	}
+	for _, n := range neighbours {
+		result := n.Item.ResultAt(horizonIndex)
+		explanation.Neighbours = append(explanation.Neighbours, ExplainedNeighbour{
+			Index:     n.Index,
+			StartTime: n.Item.StartTime,
+			EndTime:   n.Item.EndTime,
+			Distance:  n.Distance,
+			Result:    result,
+			Class:     classNames[predictOpts.Classify(result)],
+			Closes:    n.Item.Data.Closes,
+		})
+	}
+	return explanation, nil
+}
+
+func formatSpan(start, end int64) string {
+	const layout = "2006-01-02 15:04"
+	return fmt.Sprintf("%s to %s", time.UnixMilli(start).UTC().Format(layout), time.UnixMilli(end).UTC().Format(layout))
+}
+
+// VoteChart breaks down how much of the neighbours' weight went to each
+// class
+func VoteChart(explanation *Explanation) *charts.Pie {
+	pie := charts.NewPie()
+
+	prediction := explanation.Prediction
+	pie.AddSeries("Votes", []opts.PieData{
+		{Name: "buy", Value: prediction.Votes.Buy},
+		{Name: "sell", Value: prediction.Votes.Sell},
+		{Name: "hold", Value: prediction.Votes.Hold},
+	}, charts.WithLabelOpts(opts.Label{
+		Show:      true,
+		Formatter: "{b}: {d}%",
+	}))
+
+	pie.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("Prediction: %s with confidence %.4f", classNames[prediction.Signal], prediction.Confidence),
+			Subtitle: fmt.Sprintf("%s, expected result %.4f ± %.4f from %d neighbours",
+				formatSpan(explanation.StartTime, explanation.EndTime), prediction.Expected, prediction.Dispersion, prediction.Neighbours),
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+	)
+
+	return pie
+}
+
+// NeighbourCharts overlay the observation's normalised closes on each of
+// its neighbours', nearest first
+func NeighbourCharts(explanation *Explanation) []*charts.Line {
+	axis := make([]int, len(explanation.Closes))
+	observationData := make([]opts.LineData, len(explanation.Closes))
+	for i, c := range explanation.Closes {
+		axis[i] = i + 1
+		observationData[i] = opts.LineData{Value: c}
+	}
+
+	lines := make([]*charts.Line, len(explanation.Neighbours))
+	for n, neighbour := range explanation.Neighbours {
+		line := charts.NewLine()
+		neighbourData := make([]opts.LineData, len(neighbour.Closes))
+		for i, c := range neighbour.Closes {
+			neighbourData[i] = opts.LineData{Value: c}
+		}
+		line.SetXAxis(axis).
+			AddSeries("Observation", observationData).
+			AddSeries("Neighbour", neighbourData)
+
+		line.SetGlobalOptions(
+			charts.WithTitleOpts(opts.Title{
+				Title: fmt.Sprintf("Neighbour %d: %s", n+1, formatSpan(neighbour.StartTime, neighbour.EndTime)),
+				Subtitle: fmt.Sprintf("item %d, distance %.6f, result %.4f (%s)",
+					neighbour.Index, neighbour.Distance, neighbour.Result, neighbour.Class),
+			}),
+			charts.WithXAxisOpts(opts.XAxis{
+				Name: "Bar",
+			}),
+			charts.WithTooltipOpts(opts.Tooltip{
+				Show:    true,
+				Trigger: "axis",
+			}),
+			charts.WithLegendOpts(opts.Legend{
+				Show: true,
+				Top:  "bottom",
+			}),
+		)
+		lines[n] = line
+	}
+	return lines
+}
@@ -0,0 +1,57 @@
+package eval
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestExplain(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]record.Market, 100)
+	for i := range data {
+		data[i] = record.Market{
+			Timestamp: int64(i),
+			Open:      rng.Float64(),
+			High:      rng.Float64(),
+			Low:       rng.Float64(),
+			Close:     rng.Float64(),
+			Volume:    rng.Float64(),
+			VWAP:      rng.Float64(),
+		}
+	}
+	spliceOpts := splicer.SpliceOptions{
+		Period:            8,
+		ResultN:           2,
+		SkipN:             1,
+		NormalisationType: record.ZScore,
+	}
+	m := model.NewCompressionModel(spliceOpts, model.SimpleEncoding, record.InterleaveCombine)
+	assert.NoError(t, m.AddMarketData(data[:80]))
+
+	predictOpts := model.PredictionOpts{
+		Strategy: model.DiscreteWNN,
+		NearestN: 3,
+	}
+	explanation, err := Explain(m, data, predictOpts)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(92), explanation.StartTime)
+	assert.Equal(t, int64(99), explanation.EndTime)
+	assert.Len(t, explanation.Closes, 8)
+	assert.Len(t, explanation.Neighbours, 3)
+	for i, n := range explanation.Neighbours {
+		item := m.Item(n.Index)
+		assert.Equal(t, item.StartTime, n.StartTime)
+		assert.Equal(t, item.Result, n.Result)
+		assert.Equal(t, classNames[predictOpts.Classify(n.Result)], n.Class)
+		if i > 0 {
+			assert.LessOrEqual(t, explanation.Neighbours[i-1].Distance, n.Distance)
+		}
+	}
+	assert.Len(t, NeighbourCharts(explanation), 3)
+}
@@ -0,0 +1,147 @@
+package eval
+
+import (
+	"errors"
+	"math"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+type HorizonAgreement struct {
+	Horizon int `json:"horizon"`
+	// Agreement is the share of neighbours whose result is in the same
+	// class as the item's own result
+	Agreement float64 `json:"agreement"`
+	// Correlation is between each item's result and the mean result of
+	// its neighbours
+	Correlation float64 `json:"correlation"`
+}
+
+// NeighbourAgreementByHorizon measures how well each item's nearest
+// neighbours agree with its own outcome at every horizon the model records.
+// Neighbours that overlap the item in time, or are within window of doing
+// so, are skipped as their outcomes share data with the item's.
+func NeighbourAgreementByHorizon(m model.Model, nearestN int, window int64) ([]HorizonAgreement, error) {
+	if m.Len() > 0 && m.Item(0).ResultTime == 0 {
+		return nil, errors.New("model items have no timestamps, rebuild the model")
+	}
+	distanceMap, err := m.DistanceMap()
+	if err != nil {
+		return nil, err
+	}
+	spliceOpts := m.Options()
+	horizons := spliceOpts.AllHorizons()
+	var classOpts model.PredictionOpts
+	classOpts.BuyThreshold, classOpts.SellThreshold = splicer.Thresholds(spliceOpts)
+
+	agreed := make([]float64, len(horizons))
+	compared := make([]float64, len(horizons))
+	own := make([][]float64, len(horizons))
+	theirs := make([][]float64, len(horizons))
+
+	var row []float64
+	var neighbours []int
+	for i := 0; i < distanceMap.Len(); i++ {
+		item := m.Item(i)
+		exclude := func(j int) bool {
+			return item.Overlaps(m.Item(j), window)
+		}
+		neighbours, _, row, err = nearestInMatrix(distanceMap, i, nearestN, row, exclude)
+		if err != nil {
+			return nil, err
+		}
+		if len(neighbours) == 0 {
+			continue
+		}
+		for h := range horizons {
+			result := item.ResultAt(h)
+			class := classOpts.Classify(result)
+			mean := float64(0)
+			for _, j := range neighbours {
+				neighbourResult := m.Item(j).ResultAt(h)
+				mean += neighbourResult
+				if classOpts.Classify(neighbourResult) == class {
+					agreed[h]++
+				}
+				compared[h]++
+			}
+			own[h] = append(own[h], result)
+			theirs[h] = append(theirs[h], mean/float64(len(neighbours)))
+		}
+	}
+
+	res := make([]HorizonAgreement, len(horizons))
+	for h, horizon := range horizons {
+		res[h] = HorizonAgreement{
+			Horizon:     horizon,
+			Correlation: pearson(own[h], theirs[h]),
+		}
+		// every neighbour may have been excluded
+		if compared[h] > 0 {
+			res[h].Agreement = agreed[h] / compared[h]
+		}
+	}
+	return res, nil
+}
+
+func pearson(xs, ys []float64) float64 {
+	N := float64(len(xs))
+	if N == 0 {
+		return 0
+	}
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= N
+	meanY /= N
+	var covariance, varX, varY float64
+	for i := range xs {
+		covariance += (xs[i] - meanX) * (ys[i] - meanY)
+		varX += (xs[i] - meanX) * (xs[i] - meanX)
+		varY += (ys[i] - meanY) * (ys[i] - meanY)
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return covariance / math.Sqrt(varX*varY)
+}
+
+func HorizonAgreementChart(agreements []HorizonAgreement) *charts.Line {
+	line := charts.NewLine()
+
+	axis := make([]int, len(agreements))
+	agreementData := make([]opts.LineData, len(agreements))
+	correlationData := make([]opts.LineData, len(agreements))
+	for i, a := range agreements {
+		axis[i] = a.Horizon
+		agreementData[i] = opts.LineData{Value: a.Agreement}
+		correlationData[i] = opts.LineData{Value: a.Correlation}
+	}
+
+	line.SetXAxis(axis).
+		AddSeries("Class agreement", agreementData).
+		AddSeries("Result correlation", correlationData)
+
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Neighbour Agreement by Horizon",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Horizon",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return line
+}
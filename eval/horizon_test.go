@@ -0,0 +1,108 @@
+package eval
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// matrixModel only holds items and their distances, which are how far
+// apart their positions are
+type matrixModel struct {
+	model.Model
+	opts   splicer.SpliceOptions
+	items  []model.Item
+	matrix *model.DistanceMatrix
+}
+
+func newMatrixModel(t *testing.T, opts splicer.SpliceOptions, items []model.Item, positions []float64) *matrixModel {
+	keys := make([]uint64, len(items))
+	for i := range keys {
+		keys[i] = uint64(i)
+	}
+	matrix, err := model.UpdateDistanceMatrix(nil, nil, keys, filepath.Join(t.TempDir(), "model.dist"), func() (model.DistanceFunc, func(), error) {
+		return func(i, j int) (float64, error) {
+			return math.Abs(positions[i] - positions[j]), nil
+		}, func() {}, nil
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		matrix.Close()
+	})
+	return &matrixModel{opts: opts, items: items, matrix: matrix}
+}
+
+func (m *matrixModel) DistanceMap() (*model.DistanceMatrix, error) {
+	return m.matrix, nil
+}
+
+func (m *matrixModel) Options() splicer.SpliceOptions {
+	return m.opts
+}
+
+func (m *matrixModel) Len() int {
+	return len(m.items)
+}
+
+func (m *matrixModel) Item(i int) model.Item {
+	return m.items[i]
+}
+
+func TestNeighbourAgreementByHorizon(t *testing.T) {
+	opts := splicer.SpliceOptions{
+		Period:    2,
+		ResultN:   1,
+		Horizons:  []int{3},
+		LabelType: splicer.SignLabel,
+	}
+	// two pairs of items, each item's nearest neighbour is the other item
+	// in its pair. Both pairs agree at horizon 1, but only the second
+	// agrees at horizon 3.
+	results := [][]float64{{1, 1}, {1, -1}, {-1, 1}, {-1, 1}}
+	items := make([]model.Item, len(results))
+	for i, r := range results {
+		items[i] = model.Item{
+			Result:     r[0],
+			Results:    r,
+			StartTime:  int64(i * 100),
+			EndTime:    int64(i*100 + 5),
+			ResultTime: int64(i*100 + 10),
+		}
+	}
+	m := newMatrixModel(t, opts, items, []float64{0, 1, 10, 11})
+
+	agreements, err := NeighbourAgreementByHorizon(m, 1, 0)
+	assert.NoError(t, err)
+	assert.Len(t, agreements, 2)
+
+	assert.Equal(t, 1, agreements[0].Horizon)
+	assert.Equal(t, float64(1), agreements[0].Agreement)
+	assert.InDelta(t, 1, agreements[0].Correlation, 1e-9)
+
+	assert.Equal(t, 3, agreements[1].Horizon)
+	assert.Equal(t, 0.5, agreements[1].Agreement)
+	assert.InDelta(t, -1.0/3, agreements[1].Correlation, 1e-9)
+
+	// a window reaching the next item in time excludes it, leaving each
+	// item compared with one from the other pair
+	agreements, err = NeighbourAgreementByHorizon(m, 1, 90)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), agreements[0].Agreement)
+	assert.Equal(t, 0.5, agreements[1].Agreement)
+
+	// with every neighbour excluded there is nothing to agree with
+	agreements, err = NeighbourAgreementByHorizon(m, 1, 1000)
+	assert.NoError(t, err)
+	for _, a := range agreements {
+		assert.Equal(t, float64(0), a.Agreement)
+		assert.Equal(t, float64(0), a.Correlation)
+	}
+
+	_, err = NeighbourAgreementByHorizon(m, 0, 0)
+	assert.Error(t, err)
+}
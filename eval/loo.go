@@ -0,0 +1,138 @@
+package eval
+
+import (
+	"errors"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+type LOOAccuracy struct {
+	K        int     `json:"k"`
+	Accuracy float64 `json:"accuracy"`
+	// Baseline is the accuracy of always predicting the most common class
+	Baseline float64 `json:"baseline"`
+}
+
+// looNeighbours finds the k nearest neighbours of every item in the
+// distance map, skipping those that overlap it in time or are within
+// window of doing so
+func looNeighbours(m model.Model, k int, window int64) ([][]int, [][]float64, error) {
+	if m.Len() > 0 && m.Item(0).ResultTime == 0 {
+		return nil, nil, errors.New("model items have no timestamps, rebuild the model")
+	}
+	distanceMap, err := m.DistanceMap()
+	if err != nil {
+		return nil, nil, err
+	}
+	neighbours := make([][]int, distanceMap.Len())
+	distances := make([][]float64, distanceMap.Len())
+	var row []float64
+	for i := range neighbours {
+		item := m.Item(i)
+		exclude := func(j int) bool {
+			return item.Overlaps(m.Item(j), window)
+		}
+		neighbours[i], distances[i], row, err = nearestInMatrix(distanceMap, i, k, row, exclude)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return neighbours, distances, nil
+}
+
+// LeaveOneOutAccuracy predicts every item from its k nearest other items
+// in the distance map, for every k up to maxK. Neighbours that overlap the
+// item in time, or are within window of doing so, are not considered as
+// they would share data with it.
+func LeaveOneOutAccuracy(m model.Model, maxK int, window int64, predictOpts model.PredictionOpts) ([]LOOAccuracy, error) {
+	if maxK < 1 {
+		return nil, errors.New("number of neighbours must be positive")
+	}
+	horizonIndex, err := m.Options().HorizonIndex(predictOpts.Horizon)
+	if err != nil {
+		return nil, err
+	}
+	neighbours, distances, err := looNeighbours(m, maxK, window)
+	if err != nil {
+		return nil, err
+	}
+
+	correct := make([]int, maxK)
+	predicted := make([]int, maxK)
+	classCounts := make(map[int]int)
+	results := make([]float64, maxK)
+	for i := range neighbours {
+		actual := predictOpts.Classify(m.Item(i).ResultAt(horizonIndex))
+		classCounts[actual]++
+		for n, j := range neighbours[i] {
+			results[n] = m.Item(j).ResultAt(horizonIndex)
+		}
+		for k := 1; k <= len(neighbours[i]); k++ {
+			prediction, err := model.PredictFromResults(distances[i][:k], results[:k], predictOpts)
+			if err != nil {
+				return nil, err
+			}
+			predicted[k-1]++
+			if prediction.Signal == actual {
+				correct[k-1]++
+			}
+		}
+	}
+
+	mostCommon, total := 0, 0
+	for _, count := range classCounts {
+		total += count
+		if count > mostCommon {
+			mostCommon = count
+		}
+	}
+	res := make([]LOOAccuracy, 0, maxK)
+	for k := 1; k <= maxK; k++ {
+		if predicted[k-1] == 0 {
+			break
+		}
+		res = append(res, LOOAccuracy{
+			K:        k,
+			Accuracy: float64(correct[k-1]) / float64(predicted[k-1]),
+			Baseline: float64(mostCommon) / float64(total),
+		})
+	}
+	return res, nil
+}
+
+func LOOAccuracyChart(accuracies []LOOAccuracy) *charts.Line {
+	line := charts.NewLine()
+
+	axis := make([]int, len(accuracies))
+	accuracyData := make([]opts.LineData, len(accuracies))
+	baselineData := make([]opts.LineData, len(accuracies))
+	for i, a := range accuracies {
+		axis[i] = a.K
+		accuracyData[i] = opts.LineData{Value: a.Accuracy}
+		baselineData[i] = opts.LineData{Value: a.Baseline}
+	}
+
+	line.SetXAxis(axis).
+		AddSeries("Accuracy", accuracyData).
+		AddSeries("Majority class", baselineData)
+
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Leave One Out Accuracy by K",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "K",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return line
+}
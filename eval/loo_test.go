@@ -0,0 +1,75 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestLeaveOneOutAccuracy(t *testing.T) {
+	opts := splicer.SpliceOptions{
+		Period:  2,
+		ResultN: 1,
+	}
+	// the first two items are each other's nearest neighbours but overlap
+	// in time, so each is predicted from the third item instead
+	results := []float64{1, -1, 1, -1, -1}
+	times := []int64{0, 5, 100, 200, 300}
+	items := make([]model.Item, len(results))
+	for i, r := range results {
+		items[i] = model.Item{
+			Result:     r,
+			Results:    []float64{r},
+			StartTime:  times[i],
+			EndTime:    times[i] + 5,
+			ResultTime: times[i] + 10,
+		}
+	}
+	m := newMatrixModel(t, opts, items, []float64{0, 1, 2, 10, 12})
+
+	neighbours, _, err := looNeighbours(m, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{{2, 3}, {2, 3}, {1, 0}, {4, 2}, {3, 2}}, neighbours)
+
+	accuracies, err := LeaveOneOutAccuracy(m, 2, 0, model.PredictionOpts{
+		Strategy:      model.DiscreteWNN,
+		BuyThreshold:  0.5,
+		SellThreshold: -0.5,
+	})
+	assert.NoError(t, err)
+	// the second and third items are mispredicted at both K, the rest are
+	// predicted correctly
+	assert.Equal(t, []LOOAccuracy{
+		{K: 1, Accuracy: 0.6, Baseline: 0.6},
+		{K: 2, Accuracy: 0.6, Baseline: 0.6},
+	}, accuracies)
+
+	// a window long enough to reach the next item in time also excludes
+	// it, leaving the second and fourth items predicted correctly
+	neighbours, _, err = looNeighbours(m, 1, 90)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{{3}, {3}, {4}, {1}, {2}}, neighbours)
+	accuracies, err = LeaveOneOutAccuracy(m, 1, 90, model.PredictionOpts{
+		Strategy:      model.DiscreteWNN,
+		BuyThreshold:  0.5,
+		SellThreshold: -0.5,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []LOOAccuracy{{K: 1, Accuracy: 0.4, Baseline: 0.6}}, accuracies)
+
+	_, err = LeaveOneOutAccuracy(m, 0, 0, model.PredictionOpts{Strategy: model.DiscreteWNN})
+	assert.Error(t, err)
+}
+
+func TestLOOAccuracyChart(t *testing.T) {
+	line := LOOAccuracyChart([]LOOAccuracy{
+		{K: 1, Accuracy: 0.6, Baseline: 0.5},
+		{K: 2, Accuracy: 0.7, Baseline: 0.5},
+	})
+	assert.Len(t, line.MultiSeries, 2)
+	assert.Equal(t, "Accuracy", line.MultiSeries[0].Name)
+	assert.Len(t, line.MultiSeries[0].Data, 2)
+}
@@ -0,0 +1,44 @@
+package eval
+
+import (
+	"errors"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+// nearestInMatrix returns the indexes and distances of the k items closest
+// to item i in the distance matrix, ordered by ascending distance. Item i
+// itself and any item that exclude returns true for are skipped. row is
+// used as scratch space and returned for reuse.
+func nearestInMatrix(matrix *model.DistanceMatrix, i, k int, row []float64, exclude func(j int) bool) ([]int, []float64, []float64, error) {
+	if k < 1 {
+		return nil, nil, row, errors.New("number of neighbours must be positive")
+	}
+	row, err := matrix.Row(i, row)
+	if err != nil {
+		return nil, nil, row, err
+	}
+	indexes := make([]int, 0, k)
+	distances := make([]float64, 0, k)
+	for j, dist := range row {
+		if j == i || (exclude != nil && exclude(j)) {
+			continue
+		}
+		if len(indexes) == k && dist >= distances[k-1] {
+			continue
+		}
+		insertAt := len(indexes)
+		for insertAt > 0 && distances[insertAt-1] > dist {
+			insertAt--
+		}
+		if len(indexes) < k {
+			indexes = append(indexes, 0)
+			distances = append(distances, 0)
+		}
+		copy(indexes[insertAt+1:], indexes[insertAt:])
+		copy(distances[insertAt+1:], distances[insertAt:])
+		indexes[insertAt] = j
+		distances[insertAt] = dist
+	}
+	return indexes, distances, row, nil
+}
@@ -0,0 +1,310 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// HeldOutPredictions predicts every splice of data, which should not
+// overlap the data m was built from
+func HeldOutPredictions(m model.Model, data []record.Market, opts model.PredictionOpts) ([]PredictionRecord, error) {
+	horizonIndex, err := m.Options().HorizonIndex(opts.Horizon)
+	if err != nil {
+		return nil, err
+	}
+	it, err := splicer.NewIterator(data, m.Options())
+	if err != nil {
+		return nil, err
+	}
+	var splices []splicer.Splice
+	for it.Next() {
+		splices = append(splices, it.Item())
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	if len(splices) == 0 {
+		return nil, errors.New("held out data is too short for a single splice")
+	}
+	predictions, err := PredictSplices(m, splices, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]PredictionRecord, len(splices))
+	for i, s := range splices {
+		actual := s.Results[horizonIndex]
+		records[i] = PredictionRecord{
+			StartTime:   s.StartTime,
+			EndTime:     s.EndTime,
+			Actual:      actual,
+			ActualClass: opts.Classify(actual),
+			Prediction:  predictions[i],
+		}
+	}
+	return records, nil
+}
+
+var confusionClasses = []int{model.Sell, model.Hold, model.Buy}
+
+// ConfusionMatrixChart counts predictions by their predicted class, along
+// the x axis, and actual class, along the y axis
+func ConfusionMatrixChart(records []PredictionRecord) *charts.HeatMap {
+	hmap := charts.NewHeatMap()
+
+	labels := make([]string, len(confusionClasses))
+	for i, class := range confusionClasses {
+		labels[i] = classNames[class]
+	}
+	counts := make(map[[2]int]int)
+	for _, r := range records {
+		counts[[2]int{r.Prediction.Signal, r.ActualClass}]++
+	}
+	hmData := make([]opts.HeatMapData, 0, len(labels)*len(labels))
+	max := 0
+	for i, predicted := range confusionClasses {
+		for j, actual := range confusionClasses {
+			count := counts[[2]int{predicted, actual}]
+			hmData = append(hmData, opts.HeatMapData{Value: [3]interface{}{i, j, count}})
+			if count > max {
+				max = count
+			}
+		}
+	}
+
+	hmap.AddSeries("Predictions", hmData, charts.WithLabelOpts(opts.Label{
+		Show: true,
+	}))
+	hmap.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Confusion Matrix",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Predicted",
+			Type: "category",
+			Data: labels,
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: "Actual",
+			Type: "category",
+			Data: labels,
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Calculable: true,
+			Max:        float32(max),
+			InRange: &opts.VisualMapInRange{
+				Color: []string{"#50a3ba", "#eac736", "#d94e5d"},
+			},
+		}),
+	)
+
+	return hmap
+}
+
+type ReliabilityBucket struct {
+	Lower          float64 `json:"lower"`
+	Upper          float64 `json:"upper"`
+	MeanConfidence float64 `json:"mean_confidence"`
+	HitRate        float64 `json:"hit_rate"`
+	Count          int     `json:"count"`
+}
+
+// Reliability buckets predictions by confidence, a well calibrated model's
+// hit rate in each bucket matches its mean confidence. Empty buckets are
+// left out.
+func Reliability(records []PredictionRecord, buckets int) ([]ReliabilityBucket, error) {
+	if buckets < 1 {
+		return nil, errors.New("number of buckets must be positive")
+	}
+	all := make([]ReliabilityBucket, buckets)
+	for i := range all {
+		all[i].Lower = float64(i) / float64(buckets)
+		all[i].Upper = float64(i+1) / float64(buckets)
+	}
+	for _, r := range records {
+		i := int(r.Prediction.Confidence * float64(buckets))
+		if i >= buckets {
+			i = buckets - 1
+		} else if i < 0 {
+			i = 0
+		}
+		all[i].Count++
+		all[i].MeanConfidence += r.Prediction.Confidence
+		if r.Prediction.Signal == r.ActualClass {
+			all[i].HitRate++
+		}
+	}
+
+	filled := make([]ReliabilityBucket, 0, buckets)
+	for _, b := range all {
+		if b.Count == 0 {
+			continue
+		}
+		b.MeanConfidence /= float64(b.Count)
+		b.HitRate /= float64(b.Count)
+		filled = append(filled, b)
+	}
+	return filled, nil
+}
+
+func ReliabilityChart(buckets []ReliabilityBucket) *charts.Line {
+	line := charts.NewLine()
+
+	axis := make([]string, len(buckets))
+	hitRateData := make([]opts.LineData, len(buckets))
+	confidenceData := make([]opts.LineData, len(buckets))
+	for i, b := range buckets {
+		axis[i] = fmt.Sprintf("%.2f-%.2f (%d)", b.Lower, b.Upper, b.Count)
+		hitRateData[i] = opts.LineData{Value: b.HitRate}
+		confidenceData[i] = opts.LineData{Value: b.MeanConfidence}
+	}
+
+	line.SetXAxis(axis).
+		AddSeries("Hit rate", hitRateData).
+		AddSeries("Mean confidence", confidenceData)
+
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Reliability Diagram",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Confidence",
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Min: 0,
+			Max: 1,
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return line
+}
+
+type ROCPoint struct {
+	Threshold         float64 `json:"threshold"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	TruePositiveRate  float64 `json:"true_positive_rate"`
+}
+
+type ROCCurve struct {
+	Class  string     `json:"class"`
+	Points []ROCPoint `json:"points"`
+	AUC    float64    `json:"auc"`
+}
+
+// ROC scores each prediction by its expected result, or its negation for
+// sells, and traces how well thresholding the score separates the actual
+// members of class from everything else
+func ROC(records []PredictionRecord, class int) (*ROCCurve, error) {
+	if class != model.Buy && class != model.Sell {
+		return nil, errors.New("roc curves can only be traced for buys or sells")
+	}
+	type scored struct {
+		score    float64
+		positive bool
+	}
+	scores := make([]scored, len(records))
+	positives, negatives := 0, 0
+	for i, r := range records {
+		scores[i].score = r.Prediction.Expected
+		if class == model.Sell {
+			scores[i].score = -scores[i].score
+		}
+		scores[i].positive = r.ActualClass == class
+		if scores[i].positive {
+			positives++
+		} else {
+			negatives++
+		}
+	}
+	if positives == 0 || negatives == 0 {
+		return nil, errors.New("roc curves need both members and non members of the class")
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
+
+	curve := &ROCCurve{
+		Class:  classNames[class],
+		Points: []ROCPoint{{Threshold: scores[0].score}},
+	}
+	truePositives, falsePositives := 0, 0
+	for i, s := range scores {
+		if s.positive {
+			truePositives++
+		} else {
+			falsePositives++
+		}
+		// tied scores can't be separated, so only step past the last of them
+		if i+1 < len(scores) && scores[i+1].score == s.score {
+			continue
+		}
+		prev := curve.Points[len(curve.Points)-1]
+		point := ROCPoint{
+			Threshold:         s.score,
+			FalsePositiveRate: float64(falsePositives) / float64(negatives),
+			TruePositiveRate:  float64(truePositives) / float64(positives),
+		}
+		curve.AUC += (point.FalsePositiveRate - prev.FalsePositiveRate) * (point.TruePositiveRate + prev.TruePositiveRate) / 2
+		curve.Points = append(curve.Points, point)
+	}
+	return curve, nil
+}
+
+func ROCChart(curves []*ROCCurve) *charts.Line {
+	line := charts.NewLine()
+
+	for _, curve := range curves {
+		data := make([]opts.LineData, len(curve.Points))
+		for i, p := range curve.Points {
+			data[i] = opts.LineData{Value: [2]float64{p.FalsePositiveRate, p.TruePositiveRate}}
+		}
+		line.AddSeries(fmt.Sprintf("%s (AUC %.3f)", curve.Class, curve.AUC), data)
+	}
+	line.AddSeries("Chance", []opts.LineData{
+		{Value: [2]float64{0, 0}},
+		{Value: [2]float64{1, 1}},
+	})
+
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "ROC Curve",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "False positive rate",
+			Type: "value",
+			Min:  0,
+			Max:  1,
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: "True positive rate",
+			Type: "value",
+			Min:  0,
+			Max:  1,
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	return line
+}
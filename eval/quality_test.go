@@ -0,0 +1,66 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+func TestROC(t *testing.T) {
+	record := func(expected float64, actualClass int) PredictionRecord {
+		return PredictionRecord{
+			ActualClass: actualClass,
+			Prediction:  &model.Prediction{Expected: expected},
+		}
+	}
+	records := []PredictionRecord{
+		record(3, model.Buy),
+		record(2, model.Hold),
+		record(1, model.Buy),
+		record(-1, model.Sell),
+	}
+
+	curve, err := ROC(records, model.Buy)
+	assert.NoError(t, err)
+	assert.Equal(t, "buy", curve.Class)
+	assert.Len(t, curve.Points, 5)
+	assert.Equal(t, 0.75, curve.AUC)
+
+	curve, err = ROC(records, model.Sell)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), curve.AUC)
+
+	_, err = ROC(records, model.Hold)
+	assert.Error(t, err)
+}
+
+func TestReliability(t *testing.T) {
+	record := func(confidence float64, hit bool) PredictionRecord {
+		r := PredictionRecord{
+			ActualClass: model.Buy,
+			Prediction:  &model.Prediction{Signal: model.Buy, Confidence: confidence},
+		}
+		if !hit {
+			r.ActualClass = model.Sell
+		}
+		return r
+	}
+	buckets, err := Reliability([]PredictionRecord{
+		record(0.1, false),
+		record(0.9, true),
+		record(1, false),
+	}, 2)
+
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 2)
+	assert.Equal(t, 1, buckets[0].Count)
+	assert.Equal(t, float64(0), buckets[0].HitRate)
+	assert.Equal(t, 2, buckets[1].Count)
+	assert.Equal(t, 0.5, buckets[1].HitRate)
+	assert.InDelta(t, 0.95, buckets[1].MeanConfidence, 1e-9)
+
+	_, err = Reliability(nil, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,254 @@
+package eval
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/hubertkaluzny/silly-trader/backtest"
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+// SignificanceTest compares an observed statistic against its distribution
+// under a null hypothesis, PValue is the chance of doing at least as well
+// under the null
+type SignificanceTest struct {
+	Observed float64 `json:"observed"`
+	NullMean float64 `json:"null_mean"`
+	NullStd  float64 `json:"null_std"`
+	PValue   float64 `json:"p_value"`
+	Samples  int     `json:"samples"`
+}
+
+func significance(observed float64, null []float64) SignificanceTest {
+	test := SignificanceTest{
+		Observed: observed,
+		Samples:  len(null),
+	}
+	atLeast := 0
+	for _, v := range null {
+		test.NullMean += v
+		if v >= observed {
+			atLeast++
+		}
+	}
+	test.NullMean /= float64(len(null))
+	for _, v := range null {
+		test.NullStd += math.Pow(v-test.NullMean, 2)
+	}
+	test.NullStd = math.Sqrt(test.NullStd / float64(len(null)))
+	// counting the observation itself keeps the p-value above zero
+	test.PValue = float64(atLeast+1) / float64(len(null)+1)
+	return test
+}
+
+type SkillSignificance struct {
+	K int `json:"k"`
+	// Permutation shuffles which item each result belongs to, so the
+	// neighbours are kept but any link between them and results is lost
+	Permutation SignificanceTest `json:"permutation"`
+	// RandomNeighbours predicts from k random non-overlapping items
+	RandomNeighbours SignificanceTest `json:"random_neighbours"`
+}
+
+// knnAccuracy is the share of items whose neighbours predict their class
+func knnAccuracy(neighbours [][]int, distances [][]float64, results []float64, opts model.PredictionOpts) (float64, error) {
+	correct, predicted := 0, 0
+	neighbourResults := make([]float64, 0)
+	for i := range neighbours {
+		if len(neighbours[i]) == 0 {
+			continue
+		}
+		neighbourResults = neighbourResults[:0]
+		for _, j := range neighbours[i] {
+			neighbourResults = append(neighbourResults, results[j])
+		}
+		prediction, err := model.PredictFromResults(distances[i], neighbourResults, opts)
+		if err != nil {
+			return 0, err
+		}
+		predicted++
+		if prediction.Signal == opts.Classify(results[i]) {
+			correct++
+		}
+	}
+	if predicted == 0 {
+		return 0, errors.New("no items had any neighbours")
+	}
+	return float64(correct) / float64(predicted), nil
+}
+
+// TestSkill tests whether the leave one out k-NN accuracy of a model beats
+// chance, by comparing it against samples of shuffled results and random
+// neighbours
+func TestSkill(m model.Model, k int, window int64, predictOpts model.PredictionOpts, samples int, seed int64) (*SkillSignificance, error) {
+	if k < 1 {
+		return nil, errors.New("number of neighbours must be positive")
+	}
+	if samples < 1 {
+		return nil, errors.New("number of samples must be positive")
+	}
+	horizonIndex, err := m.Options().HorizonIndex(predictOpts.Horizon)
+	if err != nil {
+		return nil, err
+	}
+	neighbours, distances, err := looNeighbours(m, k, window)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]float64, m.Len())
+	for i := range results {
+		results[i] = m.Item(i).ResultAt(horizonIndex)
+	}
+	observed, err := knnAccuracy(neighbours, distances, results, predictOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	permuted := make([]float64, samples)
+	shuffled := append([]float64(nil), results...)
+	for s := range permuted {
+		rng.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		permuted[s], err = knnAccuracy(neighbours, distances, shuffled, predictOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// random neighbours are all equally distant, and as with the real
+	// ones can't overlap the item they predict
+	random := make([]float64, samples)
+	randomNeighbours := make([][]int, len(neighbours))
+	randomDistances := make([][]float64, len(neighbours))
+	for i := range randomDistances {
+		randomDistances[i] = make([]float64, len(neighbours[i]))
+		for n := range randomDistances[i] {
+			randomDistances[i][n] = 1
+		}
+	}
+	for s := range random {
+		for i := range randomNeighbours {
+			randomNeighbours[i] = randomNeighbours[i][:0]
+			item := m.Item(i)
+			for tries := 0; len(randomNeighbours[i]) < len(neighbours[i]) && tries < 100*k; tries++ {
+				j := rng.Intn(m.Len())
+				if j != i && !item.Overlaps(m.Item(j), window) {
+					randomNeighbours[i] = append(randomNeighbours[i], j)
+				}
+			}
+			randomDistances[i] = randomDistances[i][:len(randomNeighbours[i])]
+		}
+		random[s], err = knnAccuracy(randomNeighbours, randomDistances, results, predictOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &SkillSignificance{
+		K:                k,
+		Permutation:      significance(observed, permuted),
+		RandomNeighbours: significance(observed, random),
+	}, nil
+}
+
+type Interval struct {
+	Observed float64 `json:"observed"`
+	Lower    float64 `json:"lower"`
+	Upper    float64 `json:"upper"`
+}
+
+type BootstrapResult struct {
+	BlockSize  int     `json:"block_size"`
+	Samples    int     `json:"samples"`
+	Confidence float64 `json:"confidence"`
+	// MeanReturn is per bar, Sharpe is annualised
+	MeanReturn  Interval `json:"mean_return"`
+	TotalReturn Interval `json:"total_return"`
+	Sharpe      Interval `json:"sharpe"`
+	// PValue is the chance of a mean return at least as high as the one
+	// observed if the returns were centred on zero
+	PValue float64 `json:"p_value"`
+}
+
+func returnStats(returns []float64, barsPerYear float64) (float64, float64, float64) {
+	mean, total := float64(0), float64(1)
+	for _, r := range returns {
+		mean += r
+		total *= 1 + r
+	}
+	mean /= float64(len(returns))
+	variance := float64(0)
+	for _, r := range returns {
+		variance += math.Pow(r-mean, 2)
+	}
+	std := math.Sqrt(variance / float64(len(returns)))
+	sharpe := float64(0)
+	if std > 0 {
+		sharpe = mean / std * math.Sqrt(barsPerYear)
+	}
+	return mean, total - 1, sharpe
+}
+
+func percentileInterval(observed float64, values []float64, confidence float64) Interval {
+	sort.Float64s(values)
+	tail := (1 - confidence) / 2
+	lower := int(math.Floor(tail * float64(len(values)-1)))
+	upper := int(math.Ceil((1 - tail) * float64(len(values)-1)))
+	return Interval{
+		Observed: observed,
+		Lower:    values[lower],
+		Upper:    values[upper],
+	}
+}
+
+// BlockBootstrap resamples a backtest's bar returns in blocks of
+// consecutive bars, which keeps their short term autocorrelation, to put
+// confidence intervals on its returns and Sharpe ratio
+func BlockBootstrap(result *backtest.Result, blockSize, samples int, confidence float64, seed int64) (*BootstrapResult, error) {
+	if samples < 1 {
+		return nil, errors.New("number of samples must be positive")
+	}
+	if confidence <= 0 || confidence >= 1 {
+		return nil, errors.New("confidence must be between 0 and 1")
+	}
+	returns := backtest.Returns(result.Equity)
+	if len(returns) == 0 {
+		return nil, errors.New("backtest has no returns to resample")
+	}
+	if blockSize < 1 || blockSize > len(returns) {
+		return nil, errors.New("block size must be between 1 and the number of returns")
+	}
+	barsPerYear := backtest.BarsPerYear(result.Equity)
+	mean, total, sharpe := returnStats(returns, barsPerYear)
+
+	rng := rand.New(rand.NewSource(seed))
+	means := make([]float64, samples)
+	totals := make([]float64, samples)
+	sharpes := make([]float64, samples)
+	// centring the returns on zero only shifts the resampled means, so the
+	// null distribution comes from the same resamples
+	nullMeans := make([]float64, samples)
+	resampled := make([]float64, len(returns))
+	for s := 0; s < samples; s++ {
+		for filled := 0; filled < len(resampled); {
+			start := rng.Intn(len(returns) - blockSize + 1)
+			filled += copy(resampled[filled:], returns[start:start+blockSize])
+		}
+		means[s], totals[s], sharpes[s] = returnStats(resampled, barsPerYear)
+		nullMeans[s] = means[s] - mean
+	}
+
+	return &BootstrapResult{
+		BlockSize:   blockSize,
+		Samples:     samples,
+		Confidence:  confidence,
+		MeanReturn:  percentileInterval(mean, means, confidence),
+		TotalReturn: percentileInterval(total, totals, confidence),
+		Sharpe:      percentileInterval(sharpe, sharpes, confidence),
+		PValue:      significance(mean, nullMeans).PValue,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/backtest"
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+func TestSignificance(t *testing.T) {
+	test := significance(0.6, []float64{0.4, 0.5, 0.6, 0.5})
+	assert.Equal(t, 0.5, test.NullMean)
+	assert.Equal(t, 4, test.Samples)
+	// one null sample matches the observation, plus the observation itself
+	assert.Equal(t, 0.4, test.PValue)
+
+	_, err := TestSkill(nil, 3, 0, model.PredictionOpts{}, 0, 1)
+	assert.Error(t, err)
+	_, err = TestSkill(nil, 0, 0, model.PredictionOpts{}, 10, 1)
+	assert.Error(t, err)
+}
+
+func TestBlockBootstrap(t *testing.T) {
+	result := &backtest.Result{}
+	equity := float64(100)
+	for i := 0; i < 50; i++ {
+		result.Equity = append(result.Equity, backtest.EquityPoint{
+			Timestamp: int64(i) * 3600000,
+			Equity:    equity,
+		})
+		equity *= 1.01
+	}
+
+	bootstrap, err := BlockBootstrap(result, 5, 100, 0.9, 1)
+	assert.NoError(t, err)
+	// every bar returns the same, so every resample does too
+	assert.InDelta(t, 0.01, bootstrap.MeanReturn.Lower, 1e-9)
+	assert.InDelta(t, 0.01, bootstrap.MeanReturn.Upper, 1e-9)
+	// centred on zero no resample does as well, but the observation counts
+	assert.InDelta(t, 1.0/101, bootstrap.PValue, 1e-9)
+
+	_, err = BlockBootstrap(result, 100, 100, 0.9, 1)
+	assert.Error(t, err)
+	_, err = BlockBootstrap(result, 5, 0, 0.9, 1)
+	assert.Error(t, err)
+	_, err = BlockBootstrap(result, 5, 100, 1, 1)
+	assert.Error(t, err)
+	_, err = BlockBootstrap(result, 5, 100, 0, 1)
+	assert.Error(t, err)
+}
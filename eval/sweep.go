@@ -0,0 +1,416 @@
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+type SearchType string
+
+const (
+	GridSearch   SearchType = "grid"
+	RandomSearch SearchType = "random"
+)
+
+func ToSearchType(input string) (SearchType, error) {
+	switch input {
+	case "", string(GridSearch):
+		return GridSearch, nil
+	case string(RandomSearch):
+		return RandomSearch, nil
+	}
+	return GridSearch, errors.New("invalid search type specified")
+}
+
+// SweepSpec lists the values to try for each parameter, parameters left
+// empty take the same defaults as creating a model does. A grid search
+// tries every combination, a random search Samples of them.
+type SweepSpec struct {
+	Search         string   `json:"search"`
+	Samples        int      `json:"samples"`
+	Seed           int64    `json:"seed"`
+	ModelTypes     []string `json:"model_type"`
+	Periods        []int    `json:"period"`
+	ResultNs       []int    `json:"resultn"`
+	SkipNs         []int    `json:"skipn"`
+	Normalisations []string `json:"normalisation"`
+	Encodings      []string `json:"cencoding"`
+	Combines       []string `json:"combine"`
+	DTWWindows     []int    `json:"dtw_window"`
+	Labels         []string `json:"label"`
+	NearestNs      []int    `json:"nearest"`
+	Strategies     []string `json:"strategy"`
+}
+
+func LoadSweepSpec(r io.Reader) (*SweepSpec, error) {
+	spec := &SweepSpec{}
+	if err := json.NewDecoder(r).Decode(spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Variant is a single combination of parameters from a sweep
+type Variant struct {
+	ModelType     model.ModelType               `json:"model_type"`
+	SpliceOptions splicer.SpliceOptions         `json:"splice_options"`
+	Encoding      model.CompressionEncodingType `json:"cencoding,omitempty"`
+	Combine       record.CombineStrategy        `json:"combine,omitempty"`
+	DTWWindow     int                           `json:"dtw_window,omitempty"`
+	NearestN      int                           `json:"nearest"`
+	Strategy      model.PredictionStrategy      `json:"strategy"`
+}
+
+// modelKey identifies the model a variant trains, variants that only
+// differ in how they predict share it
+func (v Variant) modelKey() string {
+	optsKey, _ := json.Marshal(v.SpliceOptions)
+	return fmt.Sprintf("%s %s %s %s %d", v.ModelType, optsKey, v.Encoding, v.Combine, v.DTWWindow)
+}
+
+// DefaultVariant holds the defaults models are created and predict with,
+// shared by the command line and sweep parameters left empty
+var DefaultVariant = Variant{
+	ModelType: model.Compression,
+	SpliceOptions: splicer.SpliceOptions{
+		Period:            24 * 7,
+		ResultN:           24,
+		SkipN:             3,
+		NormalisationType: record.ZScore,
+		LabelType:         splicer.DiffLabel,
+		TakeProfit:        0.02,
+		StopLoss:          0.02,
+	},
+	Encoding:  model.RomanEncoding,
+	Combine:   record.InterleaveCombine,
+	DTWWindow: 12,
+	NearestN:  9,
+	Strategy:  model.DiscreteWNN,
+}
+
+func (v Variant) NewModel() model.Model {
+	switch v.ModelType {
+	case model.Cosine:
+		return model.NewCosineModel(v.SpliceOptions)
+	case model.Euclidean:
+		return model.NewEuclideanModel(v.SpliceOptions)
+	case model.Correlation:
+		return model.NewCorrelationModel(v.SpliceOptions)
+	case model.DTW:
+		return model.NewDTWModel(v.SpliceOptions, v.DTWWindow)
+	}
+	return model.NewCompressionModel(v.SpliceOptions, v.Encoding, v.Combine)
+}
+
+func (v Variant) PredictionOpts() model.PredictionOpts {
+	opts := model.PredictionOpts{
+		Strategy: v.Strategy,
+		NearestN: v.NearestN,
+	}
+	opts.BuyThreshold, opts.SellThreshold = splicer.Thresholds(v.SpliceOptions)
+	return opts
+}
+
+func orDefault(values []int, def int) []int {
+	if len(values) == 0 {
+		return []int{def}
+	}
+	return values
+}
+
+func parseAll(values []string, def string, parse func(string) error) error {
+	if len(values) == 0 {
+		values = []string{def}
+	}
+	for _, value := range values {
+		if err := parse(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Variants expands the spec into the combinations to try. Parameters that
+// don't apply to a model type don't create extra variants of it.
+func (spec *SweepSpec) Variants() ([]Variant, error) {
+	search, err := ToSearchType(spec.Search)
+	if err != nil {
+		return nil, err
+	}
+	if search == RandomSearch && spec.Samples < 1 {
+		return nil, errors.New("number of samples must be positive for a random search")
+	}
+	var modelTypes []model.ModelType
+	var normalisations []record.NormalisationType
+	var encodings []model.CompressionEncodingType
+	var combines []record.CombineStrategy
+	var labels []splicer.LabelType
+	var strategies []model.PredictionStrategy
+	def := DefaultVariant
+	err = parseAll(spec.ModelTypes, string(def.ModelType), func(s string) error {
+		v, err := model.ToModelType(s)
+		modelTypes = append(modelTypes, v)
+		return err
+	})
+	if err == nil {
+		err = parseAll(spec.Normalisations, string(def.SpliceOptions.NormalisationType), func(s string) error {
+			v, err := record.ToNormalisationType(s)
+			normalisations = append(normalisations, v)
+			return err
+		})
+	}
+	if err == nil {
+		err = parseAll(spec.Encodings, string(def.Encoding), func(s string) error {
+			v, err := model.ToCompressionEncodingType(s)
+			encodings = append(encodings, v)
+			return err
+		})
+	}
+	if err == nil {
+		err = parseAll(spec.Combines, string(def.Combine), func(s string) error {
+			v, err := record.ToCombineStrategy(s)
+			combines = append(combines, v)
+			return err
+		})
+	}
+	if err == nil {
+		err = parseAll(spec.Labels, string(def.SpliceOptions.LabelType), func(s string) error {
+			v, err := splicer.ToLabelType(s)
+			labels = append(labels, v)
+			return err
+		})
+	}
+	if err == nil {
+		err = parseAll(spec.Strategies, string(def.Strategy), func(s string) error {
+			v, err := model.ToPredictionStrategy(s)
+			strategies = append(strategies, v)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// start from a single variant and multiply it by each parameter's values
+	variants := []Variant{def}
+	expand := func(n int, set func(v *Variant, i int)) {
+		expanded := make([]Variant, 0, len(variants)*n)
+		for _, v := range variants {
+			for i := 0; i < n; i++ {
+				set(&v, i)
+				expanded = append(expanded, v)
+			}
+		}
+		variants = expanded
+	}
+	expand(len(modelTypes), func(v *Variant, i int) { v.ModelType = modelTypes[i] })
+	periods := orDefault(spec.Periods, def.SpliceOptions.Period)
+	expand(len(periods), func(v *Variant, i int) { v.SpliceOptions.Period = periods[i] })
+	resultNs := orDefault(spec.ResultNs, def.SpliceOptions.ResultN)
+	expand(len(resultNs), func(v *Variant, i int) { v.SpliceOptions.ResultN = resultNs[i] })
+	skipNs := orDefault(spec.SkipNs, def.SpliceOptions.SkipN)
+	expand(len(skipNs), func(v *Variant, i int) { v.SpliceOptions.SkipN = skipNs[i] })
+	expand(len(normalisations), func(v *Variant, i int) { v.SpliceOptions.NormalisationType = normalisations[i] })
+	expand(len(labels), func(v *Variant, i int) { v.SpliceOptions.LabelType = labels[i] })
+	expand(len(encodings), func(v *Variant, i int) { v.Encoding = encodings[i] })
+	expand(len(combines), func(v *Variant, i int) { v.Combine = combines[i] })
+	dtwWindows := orDefault(spec.DTWWindows, def.DTWWindow)
+	expand(len(dtwWindows), func(v *Variant, i int) { v.DTWWindow = dtwWindows[i] })
+	nearestNs := orDefault(spec.NearestNs, def.NearestN)
+	expand(len(nearestNs), func(v *Variant, i int) { v.NearestN = nearestNs[i] })
+	expand(len(strategies), func(v *Variant, i int) { v.Strategy = strategies[i] })
+
+	unique := variants[:0]
+	seen := make(map[string]bool)
+	for _, v := range variants {
+		if v.ModelType != model.Compression {
+			v.Encoding, v.Combine = "", ""
+		}
+		if v.ModelType != model.DTW {
+			v.DTWWindow = 0
+		}
+		key := fmt.Sprintf("%s %d %s", v.modelKey(), v.NearestN, v.Strategy)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, v)
+		}
+	}
+	variants = unique
+
+	if search == RandomSearch && spec.Samples < len(variants) {
+		rng := rand.New(rand.NewSource(spec.Seed))
+		rng.Shuffle(len(variants), func(i, j int) {
+			variants[i], variants[j] = variants[j], variants[i]
+		})
+		variants = variants[:spec.Samples]
+	}
+	return variants, nil
+}
+
+type SweepResult struct {
+	Variant Variant `json:"variant"`
+	Metrics Metrics `json:"metrics"`
+	Folds   int     `json:"folds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Spread is how much better the results of predicted buys were than those
+// of predicted sells
+func (r SweepResult) Spread() float64 {
+	return r.Metrics.MeanResultPredictedBuy - r.Metrics.MeanResultPredictedSell
+}
+
+var sweepSorts = map[string]func(r SweepResult) float64{
+	"accuracy": func(r SweepResult) float64 {
+		return r.Metrics.Accuracy
+	},
+	"spread": SweepResult.Spread,
+	"buy_precision": func(r SweepResult) float64 {
+		return r.Metrics.Classes[classNames[model.Buy]].Precision
+	},
+	"sell_precision": func(r SweepResult) float64 {
+		return r.Metrics.Classes[classNames[model.Sell]].Precision
+	},
+}
+
+// SortLeaderboard orders results best first by accuracy, spread,
+// buy_precision or sell_precision, with failed variants last
+func SortLeaderboard(results []SweepResult, by string) error {
+	score, ok := sweepSorts[by]
+	if !ok {
+		return errors.New("invalid leaderboard sort specified")
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Error == "") != (results[j].Error == "") {
+			return results[i].Error == ""
+		}
+		return score(results[i]) > score(results[j])
+	})
+	return nil
+}
+
+// Sweep scores every variant by walk-forward evaluation over data, running
+// workers of them at once. Models and splices are cached between variants
+// that share them, variants are run grouped by the model they share and
+// each model is dropped once its last variant finishes. Variants that fail
+// are reported with their error.
+func Sweep(data []record.Market, variants []Variant, opts WalkForwardOpts, workers int, progress model.ProgressFunc) []SweepResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if opts.Cache == nil {
+		opts.Cache = NewWalkForwardCache()
+	}
+	results := make([]SweepResult, len(variants))
+	order := make([]int, len(variants))
+	remaining := make(map[string]int)
+	for i, v := range variants {
+		order[i] = i
+		remaining[v.modelKey()]++
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return variants[order[a]].modelKey() < variants[order[b]].modelKey()
+	})
+	indexes := make(chan int)
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				v := variants[i]
+				variantOpts := opts
+				variantOpts.Prediction = v.PredictionOpts()
+				variantOpts.CacheKey = v.modelKey()
+				result := SweepResult{Variant: v}
+				report, err := WalkForward(data, v.NewModel, variantOpts)
+				if err != nil {
+					result.Error = err.Error()
+				} else {
+					result.Metrics = report.Overall
+					result.Folds = len(report.Folds)
+				}
+				results[i] = result
+
+				mu.Lock()
+				done++
+				remaining[variantOpts.CacheKey]--
+				if remaining[variantOpts.CacheKey] == 0 {
+					opts.Cache.Evict(variantOpts.CacheKey)
+				}
+				if progress != nil {
+					progress(done, len(variants))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, i := range order {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// WriteLeaderboardCSV writes a row per result, in the order given
+func WriteLeaderboardCSV(w io.Writer, results []SweepResult) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{
+		"rank", "model_type", "period", "resultn", "skipn", "normalisation", "label",
+		"cencoding", "combine", "dtw_window", "nearest", "strategy", "folds", "count",
+		"accuracy", "buy_precision", "sell_precision", "mean_result_predicted_buy",
+		"mean_result_predicted_sell", "spread", "error",
+	})
+	if err != nil {
+		return err
+	}
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for i, r := range results {
+		v := r.Variant
+		err := writer.Write([]string{
+			strconv.Itoa(i + 1),
+			string(v.ModelType),
+			strconv.Itoa(v.SpliceOptions.Period),
+			strconv.Itoa(v.SpliceOptions.ResultN),
+			strconv.Itoa(v.SpliceOptions.SkipN),
+			string(v.SpliceOptions.NormalisationType),
+			string(v.SpliceOptions.LabelType),
+			string(v.Encoding),
+			string(v.Combine),
+			strconv.Itoa(v.DTWWindow),
+			strconv.Itoa(v.NearestN),
+			string(v.Strategy),
+			strconv.Itoa(r.Folds),
+			strconv.Itoa(r.Metrics.Count),
+			float(r.Metrics.Accuracy),
+			float(r.Metrics.Classes[classNames[model.Buy]].Precision),
+			float(r.Metrics.Classes[classNames[model.Sell]].Precision),
+			float(r.Metrics.MeanResultPredictedBuy),
+			float(r.Metrics.MeanResultPredictedSell),
+			float(r.Spread()),
+			r.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,42 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+)
+
+func TestSweepVariants(t *testing.T) {
+	spec := &SweepSpec{
+		ModelTypes: []string{"compression", "cosine"},
+		Periods:    []int{24, 48},
+		Encodings:  []string{"roman", "simple"},
+		NearestNs:  []int{5, 9},
+	}
+	variants, err := spec.Variants()
+	assert.NoError(t, err)
+	// encodings only multiply compression variants
+	assert.Len(t, variants, 2*2*2+2*2)
+	for _, v := range variants {
+		assert.Equal(t, 24, v.SpliceOptions.ResultN)
+		if v.ModelType == model.Cosine {
+			assert.Empty(t, v.Encoding)
+		}
+	}
+
+	spec.Search = "random"
+	spec.Samples = 5
+	sampled, err := spec.Variants()
+	assert.NoError(t, err)
+	assert.Len(t, sampled, 5)
+
+	spec.Samples = 0
+	_, err = spec.Variants()
+	assert.Error(t, err)
+
+	spec.Strategies = []string{"nope"}
+	_, err = spec.Variants()
+	assert.Error(t, err)
+}
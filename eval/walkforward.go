@@ -0,0 +1,370 @@
+package eval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// ModelFactory creates a new, empty model to be trained
+type ModelFactory func() model.Model
+
+type WalkForwardOpts struct {
+	// TestStart is the timestamp the first test window begins at
+	TestStart int64
+	// TestDuration is how long each test window lasts, each fold's test
+	// window starts where the previous one ended
+	TestDuration int64
+	// TrainDuration is how far back from the test window to train on,
+	// 0 trains on everything before the test window
+	TrainDuration int64
+	// Folds is how many folds to run, 0 runs until the data runs out
+	Folds      int
+	Prediction model.PredictionOpts
+	// Cache shares trained models and test splices between runs, models
+	// are shared between runs with the same CacheKey
+	Cache    *WalkForwardCache
+	CacheKey string
+}
+
+type PredictionRecord struct {
+	Fold      int     `json:"fold"`
+	StartTime int64   `json:"start_time"`
+	EndTime   int64   `json:"end_time"`
+	Actual    float64 `json:"actual"`
+	// ActualClass is whether the actual result was a Buy, Sell or Hold
+	ActualClass int               `json:"actual_class"`
+	Prediction  *model.Prediction `json:"prediction"`
+}
+
+type ClassMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	// Support is how many splices actually were this class, and
+	// Predicted how many were predicted to be
+	Support   int `json:"support"`
+	Predicted int `json:"predicted"`
+}
+
+type Metrics struct {
+	Count    int                     `json:"count"`
+	Accuracy float64                 `json:"accuracy"`
+	Classes  map[string]ClassMetrics `json:"classes"`
+	// the mean actual result of splices predicted to be buys or sells, a
+	// useful model has the former above the latter
+	MeanResultPredictedBuy  float64 `json:"mean_result_predicted_buy"`
+	MeanResultPredictedSell float64 `json:"mean_result_predicted_sell"`
+}
+
+type FoldReport struct {
+	Fold       int     `json:"fold"`
+	TrainStart int64   `json:"train_start"`
+	TrainEnd   int64   `json:"train_end"`
+	TestStart  int64   `json:"test_start"`
+	TestEnd    int64   `json:"test_end"`
+	TrainItems int     `json:"train_items"`
+	Metrics    Metrics `json:"metrics"`
+}
+
+type WalkForwardReport struct {
+	Folds       []FoldReport       `json:"folds"`
+	Overall     Metrics            `json:"overall"`
+	Predictions []PredictionRecord `json:"predictions"`
+}
+
+var classNames = map[int]string{
+	model.Buy:  "buy",
+	model.Sell: "sell",
+	model.Hold: "hold",
+}
+
+// SplitByTime splits data that is ordered by time into the records before
+// timestamp, and the records from timestamp onwards
+func SplitByTime(data []record.Market, timestamp int64) ([]record.Market, []record.Market) {
+	split := sort.Search(len(data), func(i int) bool {
+		return data[i].Timestamp >= timestamp
+	})
+	return data[:split], data[split:]
+}
+
+// ComputeMetrics scores predictions against what actually happened
+func ComputeMetrics(predictions []PredictionRecord) Metrics {
+	metrics := Metrics{
+		Count:   len(predictions),
+		Classes: make(map[string]ClassMetrics),
+	}
+	correct := make(map[int]int)
+	support := make(map[int]int)
+	predicted := make(map[int]int)
+	var buySum, sellSum float64
+	for _, p := range predictions {
+		support[p.ActualClass]++
+		predicted[p.Prediction.Signal]++
+		if p.ActualClass == p.Prediction.Signal {
+			correct[p.ActualClass]++
+		}
+		switch p.Prediction.Signal {
+		case model.Buy:
+			buySum += p.Actual
+		case model.Sell:
+			sellSum += p.Actual
+		}
+	}
+
+	totalCorrect := 0
+	for class, name := range classNames {
+		totalCorrect += correct[class]
+		classMetrics := ClassMetrics{
+			Support:   support[class],
+			Predicted: predicted[class],
+		}
+		if predicted[class] > 0 {
+			classMetrics.Precision = float64(correct[class]) / float64(predicted[class])
+		}
+		if support[class] > 0 {
+			classMetrics.Recall = float64(correct[class]) / float64(support[class])
+		}
+		metrics.Classes[name] = classMetrics
+	}
+	if len(predictions) > 0 {
+		metrics.Accuracy = float64(totalCorrect) / float64(len(predictions))
+	}
+	if predicted[model.Buy] > 0 {
+		metrics.MeanResultPredictedBuy = buySum / float64(predicted[model.Buy])
+	}
+	if predicted[model.Sell] > 0 {
+		metrics.MeanResultPredictedSell = sellSum / float64(predicted[model.Sell])
+	}
+	return metrics
+}
+
+// testSplices returns the splices whose observation ends within
+// [start, end), their observations may begin before start
+func testSplices(data []record.Market, spliceOpts splicer.SpliceOptions, start, end int64) ([]splicer.Splice, error) {
+	before, _ := SplitByTime(data, start)
+	from := len(before) - spliceOpts.Period + 1
+	if from < 0 {
+		from = 0
+	}
+	it, err := splicer.NewIterator(data[from:], spliceOpts)
+	if err != nil {
+		return nil, err
+	}
+	var splices []splicer.Splice
+	for it.Next() {
+		s := it.Item()
+		if s.EndTime >= end {
+			break
+		}
+		if s.EndTime >= start {
+			splices = append(splices, s)
+		}
+	}
+	return splices, it.Err()
+}
+
+// WalkForwardCache holds on to what walk-forward runs compute, so runs
+// that share splices or models don't compute them again. It is safe to
+// share between concurrent runs over the same market data, and only them.
+type WalkForwardCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+func NewWalkForwardCache() *WalkForwardCache {
+	return &WalkForwardCache{
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the cached value for key, computing it if this is the first
+// time it has been asked for. A nil cache always computes it.
+func (c *WalkForwardCache) get(key string, compute func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return compute()
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+	entry.once.Do(func() {
+		entry.value, entry.err = compute()
+	})
+	return entry.value, entry.err
+}
+
+// modelCachePrefix starts the key of every fold's model cached under
+// cacheKey
+func modelCachePrefix(cacheKey string) string {
+	return fmt.Sprintf("model %s ", cacheKey)
+}
+
+// Evict drops the models of every fold cached under cacheKey, once no
+// more runs will ask for them
+func (c *WalkForwardCache) Evict(cacheKey string) {
+	if c == nil {
+		return
+	}
+	prefix := modelCachePrefix(cacheKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *WalkForwardCache) testSplices(data []record.Market, spliceOpts splicer.SpliceOptions, start, end int64) ([]splicer.Splice, error) {
+	optsKey, err := json.Marshal(spliceOpts)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("splices %s %d %d", optsKey, start, end)
+	splices, err := c.get(key, func() (interface{}, error) {
+		return testSplices(data, spliceOpts, start, end)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return splices.([]splicer.Splice), nil
+}
+
+// PredictSplices predicts every splice with m in parallel
+func PredictSplices(m model.Model, splices []splicer.Splice, opts model.PredictionOpts) ([]*model.Prediction, error) {
+	predictions := make([]*model.Prediction, len(splices))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				prediction, err := m.Predict(record.MarketToModel(splices[i].Data), opts)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+					})
+					continue
+				}
+				predictions[i] = prediction
+			}
+		}()
+	}
+	for i := range splices {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return predictions, firstErr
+}
+
+// WalkForward trains a fresh model for each fold on the data before its
+// test window, and predicts every splice in the test window with it. The
+// model never sees a result that was not known when the test window began.
+// Folds whose training window can't fill a single splice are skipped.
+func WalkForward(data []record.Market, newModel ModelFactory, opts WalkForwardOpts) (*WalkForwardReport, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no data to evaluate")
+	}
+	if opts.TestDuration <= 0 {
+		return nil, errors.New("test duration must be positive")
+	}
+	for i := 1; i < len(data); i++ {
+		if data[i].Timestamp <= data[i-1].Timestamp {
+			return nil, errors.New("market data must be in ascending time order")
+		}
+	}
+	lastTimestamp := data[len(data)-1].Timestamp
+	report := &WalkForwardReport{}
+
+	for fold := 0; opts.Folds == 0 || fold < opts.Folds; fold++ {
+		testStart := opts.TestStart + int64(fold)*opts.TestDuration
+		testEnd := testStart + opts.TestDuration
+		if testStart > lastTimestamp {
+			break
+		}
+		trainStart := data[0].Timestamp
+		if opts.TrainDuration > 0 && testStart-opts.TrainDuration > trainStart {
+			trainStart = testStart - opts.TrainDuration
+		}
+		_, fromTrainStart := SplitByTime(data, trainStart)
+		train, _ := SplitByTime(fromTrainStart, testStart)
+
+		modelKey := fmt.Sprintf("%s%d %d", modelCachePrefix(opts.CacheKey), trainStart, testStart)
+		cached, err := opts.Cache.get(modelKey, func() (interface{}, error) {
+			m := newModel()
+			return m, m.AddMarketData(train)
+		})
+		if errors.Is(err, splicer.ErrInsufficientData) {
+			// the training window can't fill a single splice yet
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		m := cached.(model.Model)
+		splices, err := opts.Cache.testSplices(data, m.Options(), testStart, testEnd)
+		if errors.Is(err, splicer.ErrInsufficientData) {
+			// the remaining data can't fill a single splice
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		predictions, err := PredictSplices(m, splices, opts.Prediction)
+		if err != nil {
+			return nil, err
+		}
+
+		horizonIndex, err := m.Options().HorizonIndex(opts.Prediction.Horizon)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]PredictionRecord, len(splices))
+		for i, s := range splices {
+			actual := s.Results[horizonIndex]
+			records[i] = PredictionRecord{
+				Fold:        fold,
+				StartTime:   s.StartTime,
+				EndTime:     s.EndTime,
+				Actual:      actual,
+				ActualClass: opts.Prediction.Classify(actual),
+				Prediction:  predictions[i],
+			}
+		}
+		report.Predictions = append(report.Predictions, records...)
+		report.Folds = append(report.Folds, FoldReport{
+			Fold:       fold,
+			TrainStart: trainStart,
+			TrainEnd:   testStart,
+			TestStart:  testStart,
+			TestEnd:    testEnd,
+			TrainItems: m.Len(),
+			Metrics:    ComputeMetrics(records),
+		})
+	}
+
+	if len(report.Folds) == 0 {
+		return nil, errors.New("not enough data for a single fold")
+	}
+	report.Overall = ComputeMetrics(report.Predictions)
+	return report, nil
+}
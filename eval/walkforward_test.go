@@ -0,0 +1,133 @@
+package eval
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/model"
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestSplitByTime(t *testing.T) {
+	data := []record.Market{{Timestamp: 1}, {Timestamp: 2}, {Timestamp: 3}}
+	before, after := SplitByTime(data, 2)
+	assert.Equal(t, data[:1], before)
+	assert.Equal(t, data[1:], after)
+
+	before, after = SplitByTime(data, 4)
+	assert.Len(t, before, 3)
+	assert.Len(t, after, 0)
+}
+
+func TestComputeMetrics(t *testing.T) {
+	predict := func(actual float64, actualClass, signal int) PredictionRecord {
+		return PredictionRecord{
+			Actual:      actual,
+			ActualClass: actualClass,
+			Prediction:  &model.Prediction{Signal: signal},
+		}
+	}
+	metrics := ComputeMetrics([]PredictionRecord{
+		predict(2, model.Buy, model.Buy),
+		predict(-1, model.Sell, model.Buy),
+		predict(-2, model.Sell, model.Sell),
+		predict(0, model.Hold, model.Hold),
+	})
+
+	assert.Equal(t, 4, metrics.Count)
+	assert.Equal(t, 0.75, metrics.Accuracy)
+	assert.Equal(t, ClassMetrics{Precision: 0.5, Recall: 1, Support: 1, Predicted: 2}, metrics.Classes["buy"])
+	assert.Equal(t, ClassMetrics{Precision: 1, Recall: 0.5, Support: 2, Predicted: 1}, metrics.Classes["sell"])
+	assert.Equal(t, 0.5, metrics.MeanResultPredictedBuy)
+	assert.Equal(t, float64(-2), metrics.MeanResultPredictedSell)
+}
+
+func TestWalkForwardCacheEvict(t *testing.T) {
+	cache := NewWalkForwardCache()
+	compute := func() (interface{}, error) {
+		return 1, nil
+	}
+	for _, key := range []string{
+		modelCachePrefix("a") + "0 10",
+		modelCachePrefix("a") + "0 20",
+		modelCachePrefix("b") + "0 10",
+		"splices {} 0 10",
+	} {
+		_, err := cache.get(key, compute)
+		assert.NoError(t, err)
+	}
+	cache.Evict("a")
+	assert.Len(t, cache.entries, 2)
+	assert.Contains(t, cache.entries, modelCachePrefix("b")+"0 10")
+}
+
+// relabelledModel splices test windows with different options to the ones
+// it was trained with
+type relabelledModel struct {
+	model.Model
+	opts splicer.SpliceOptions
+}
+
+func (m relabelledModel) Options() splicer.SpliceOptions {
+	return m.opts
+}
+
+func TestWalkForward(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]record.Market, 100)
+	for i := range data {
+		data[i] = record.Market{
+			Timestamp: int64(i),
+			Open:      rng.Float64(),
+			High:      rng.Float64(),
+			Low:       rng.Float64(),
+			Close:     rng.Float64(),
+			Volume:    rng.Float64(),
+			VWAP:      rng.Float64(),
+		}
+	}
+	spliceOpts := splicer.SpliceOptions{
+		Period:            8,
+		ResultN:           2,
+		NormalisationType: record.ZScore,
+	}
+	newModel := func() model.Model {
+		return model.NewCompressionModel(spliceOpts, model.SimpleEncoding, record.InterleaveCombine)
+	}
+	opts := WalkForwardOpts{
+		TestStart:    60,
+		TestDuration: 19,
+		Prediction: model.PredictionOpts{
+			Strategy: model.DiscreteWNN,
+			NearestN: 3,
+		},
+	}
+
+	// the third fold starts too close to the end of the data for a splice
+	report, err := WalkForward(data, newModel, opts)
+	assert.NoError(t, err)
+	assert.Len(t, report.Folds, 2)
+
+	// the first fold has too little data before it to train on
+	early := opts
+	early.TestStart = 5
+	report, err = WalkForward(data, newModel, early)
+	assert.NoError(t, err)
+	assert.Len(t, report.Folds, 4)
+	assert.Equal(t, 1, report.Folds[0].Fold)
+
+	short := opts
+	short.TrainDuration = 5
+	_, err = WalkForward(data, newModel, short)
+	assert.EqualError(t, err, "not enough data for a single fold")
+
+	badOpts := spliceOpts
+	badOpts.LabelType = "bogus"
+	_, err = WalkForward(data, func() model.Model {
+		return relabelledModel{Model: newModel(), opts: badOpts}
+	}, opts)
+	assert.EqualError(t, err, "invalid label type specified")
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// ProgressFunc is called as items are added to a model, with how many of
+// the total have been done so far
+type ProgressFunc func(done, total int)
+
+// SpliceWorker creates a function for a single goroutine that converts the
+// splice at index i of the current batch into an item, along with a
+// function to release anything it holds on to
+type SpliceWorker func() (func(i int, s splicer.Splice) error, func(), error)
+
+const defaultBatchSize = 512
+
+// BuildOptions controls how market data is streamed into a model's items,
+// only BatchSize splices are held in memory at once
+type BuildOptions struct {
+	BatchSize int          `json:"-"`
+	Workers   int          `json:"-"`
+	Progress  ProgressFunc `json:"-"`
+}
+
+func (opts *BuildOptions) SetBuildOptions(build BuildOptions) {
+	*opts = build
+}
+
+type spliceJob struct {
+	index  int
+	splice splicer.Splice
+	done   *sync.WaitGroup
+}
+
+// streamSplices splices data lazily, and converts the splices in parallel
+// batches. startBatch is called with the size of each batch before any of
+// it is converted, and endBatch once all of it has been.
+func streamSplices(data []record.Market, spliceOpts splicer.SpliceOptions, opts BuildOptions, worker SpliceWorker, startBatch func(size int), endBatch func()) error {
+	it, err := splicer.NewIterator(data, spliceOpts)
+	if err != nil {
+		return err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var errOnce sync.Once
+	var firstErr error
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+		})
+	}
+
+	jobs := make(chan spliceJob)
+	var workersWg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			convert, release, err := worker()
+			if err != nil {
+				setErr(err)
+			} else {
+				defer release()
+			}
+			for job := range jobs {
+				if convert != nil {
+					if err := convert(job.index, job.splice); err != nil {
+						setErr(err)
+					}
+				}
+				job.done.Done()
+			}
+		}()
+	}
+
+	total := it.Len()
+	done := 0
+	batch := make([]splicer.Splice, 0, batchSize)
+	flush := func() {
+		startBatch(len(batch))
+		var batchWg sync.WaitGroup
+		batchWg.Add(len(batch))
+		for i, s := range batch {
+			jobs <- spliceJob{index: i, splice: s, done: &batchWg}
+		}
+		batchWg.Wait()
+		endBatch()
+		done += len(batch)
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(done, total)
+		}
+	}
+	for it.Next() {
+		batch = append(batch, it.Item())
+		if len(batch) == batchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+	close(jobs)
+	workersWg.Wait()
+
+	if it.Err() != nil {
+		return it.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestAddMarketDataBatches(t *testing.T) {
+	data := make([]record.Market, 100)
+	for i := range data {
+		data[i] = record.Market{
+			Timestamp: int64(i),
+			Open:      rand.Float64(),
+			High:      rand.Float64(),
+			Low:       rand.Float64(),
+			Close:     rand.Float64(),
+			Volume:    rand.Float64(),
+			VWAP:      rand.Float64(),
+		}
+	}
+	opts := splicer.SpliceOptions{
+		Period:            8,
+		ResultN:           2,
+		SkipN:             1,
+		NormalisationType: record.ZScore,
+	}
+	splices, err := splicer.SpliceData(data, opts)
+	assert.NoError(t, err)
+
+	m := NewCompressionModel(opts, SimpleEncoding, record.InterleaveCombine)
+	var progress []int
+	m.SetBuildOptions(BuildOptions{
+		BatchSize: 7,
+		Workers:   3,
+		Progress: func(done, total int) {
+			assert.Equal(t, len(splices), total)
+			progress = append(progress, done)
+		},
+	})
+	assert.NoError(t, m.AddMarketData(data))
+
+	// items keep the order of the splices regardless of which worker
+	// compressed them
+	assert.Equal(t, len(splices), m.Len())
+	for i, s := range splices {
+		assert.Equal(t, record.MarketToModel(s.Data), m.Items[i].Data)
+		assert.Equal(t, s.Result, m.Items[i].Result)
+		assert.Equal(t, s.StartTime, m.Items[i].StartTime)
+		assert.Equal(t, s.EndTime, m.Items[i].EndTime)
+		assert.True(t, m.Items[i].CompressedSize > 0)
+	}
+	assert.Equal(t, len(splices), progress[len(progress)-1])
+	assert.Equal(t, (len(splices)+6)/7, len(progress))
+}
@@ -1,15 +1,12 @@
 package model
 
 import (
-	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
-	"os"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/4kills/go-libdeflate/v2"
 
@@ -17,11 +14,6 @@ import (
 	"github.com/hubertkaluzny/silly-trader/splicer"
 )
 
-type Neighbour struct {
-	Distance float64
-	Item     CompressionItem
-}
-
 type CompressionEncodingType string
 
 const (
@@ -32,19 +24,6 @@ const (
 	RomanEncoding      CompressionEncodingType = "roman"
 )
 
-type PredictionStrategy string
-
-const (
-	DiscreteWNN  PredictionStrategy = "wnn"
-	ContinousWNN PredictionStrategy = "cwnn"
-	TopDog       PredictionStrategy = "top"
-)
-
-type PredictionOpts struct {
-	Strategy PredictionStrategy
-	NearestN int
-}
-
 func ToCompressionEncodingType(input string) (CompressionEncodingType, error) {
 	switch input {
 	case string(SimpleEncoding):
@@ -61,22 +40,36 @@ func ToCompressionEncodingType(input string) (CompressionEncodingType, error) {
 	return SimpleEncoding, errors.New("invalid encoding type specified")
 }
 
+var CompressionEncodingTypes = []CompressionEncodingType{SimpleEncoding, ExpandedEncoding, SFExpandedEncoding, CharVarLength, RomanEncoding}
+
+// CompressionLevels are a spread of libdeflate compression levels, from
+// the fastest to the one with the smallest output
+var CompressionLevels = []int{libdeflate.MinCompressionLevel, libdeflate.DefaultCompressionLevel, libdeflate.MaxCompressionLevel}
+
 type CompressionItem struct {
-	Data           record.Model `json:"model"`
-	CompressedSize int          `json:"compressed_length"`
-	Result         float64      `json:"result"`
+	Item
+	CompressedSize int `json:"compressed_length"`
 }
 
 type CompressionModel struct {
-	SpliceOptions     splicer.SpliceOptions   `json:"splice_options"`
-	Items             []CompressionItem       `json:"items"`
-	EncodingType      CompressionEncodingType `json:"encoding_type"`
-	CachedDistanceMap [][]float64             `json:"distance_map"`
-	CombineStrategy   record.CombineStrategy  `json:"combine_strategy"`
+	Type            ModelType               `json:"model_type"`
+	SpliceOptions   splicer.SpliceOptions   `json:"splice_options"`
+	Items           []CompressionItem       `json:"items"`
+	EncodingType    CompressionEncodingType `json:"encoding_type"`
+	CombineStrategy record.CombineStrategy  `json:"combine_strategy"`
+	// CompressionLevel is the libdeflate level series are compressed at.
+	// Models saved before it could be chosen leave it 0, and compress items
+	// at the maximum level but distance maps at the default one.
+	CompressionLevel int `json:"compression_level,omitempty"`
+	DistanceCache
+	BuildOptions
 }
 
+var _ Model = (*CompressionModel)(nil)
+
 func NewCompressionModel(spliceOpts splicer.SpliceOptions, encodingType CompressionEncodingType, combineStrat record.CombineStrategy) *CompressionModel {
 	return &CompressionModel{
+		Type:            Compression,
 		SpliceOptions:   spliceOpts,
 		EncodingType:    encodingType,
 		CombineStrategy: combineStrat,
@@ -84,80 +77,77 @@ func NewCompressionModel(spliceOpts splicer.SpliceOptions, encodingType Compress
 }
 
 func LoadCompressionModelFromFile(file string) (*CompressionModel, error) {
-	modelFile, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer modelFile.Close()
-	reader, err := gzip.NewReader(modelFile)
+	data, err := readModelFile(file)
 	if err != nil {
 		return nil, err
 	}
+	return decodeCompressionModel(file, data)
+}
+
+// decodeCompressionModel decodes the contents of the model saved at file
+func decodeCompressionModel(file string, data []byte) (*CompressionModel, error) {
 	var model CompressionModel
-	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(&model)
+	err := json.Unmarshal(data, &model)
 	if err != nil {
 		return nil, err
 	}
+	if model.Type == "" {
+		model.Type = Compression
+	}
+	if model.Type != Compression {
+		return nil, errors.New("model is not a compression model")
+	}
+	model.setModelPath(file)
 	return &model, nil
 }
 
-func (model *CompressionModel) AddMarketData(data []record.Market) error {
-	splices, err := splicer.SpliceData(data, model.SpliceOptions)
-	if err != nil {
-		return err
-	}
-	c, err := libdeflate.NewCompressorLevel(libdeflate.MaxCompressionLevel)
-	if err != nil {
-		return err
+// newCompressor compresses at the model's level, or at legacy for models
+// saved before the level could be chosen
+func (model *CompressionModel) newCompressor(legacy int) (libdeflate.Compressor, error) {
+	level := model.CompressionLevel
+	if level == 0 {
+		level = legacy
 	}
-	newItems := make([]CompressionItem, len(splices))
-	for i, s := range splices {
-		modelData := record.MarketToModel(s.Data)
-		item, err := CompressModelData(c, modelData, model.EncodingType)
-		item.Result = s.Result
-		if err != nil {
-			return err
-		}
-		newItems[i] = *item
-	}
-	model.Items = append(model.Items, newItems...)
-	return nil
+	return libdeflate.NewCompressorLevel(level)
 }
 
-func (model *CompressionModel) PredictResults(observation record.Model, opts PredictionOpts) (int, error) {
-	results, err := model.GetClosestNeighbours(observation, opts.NearestN)
-	if err != nil {
-		return 0, err
-	}
-	// assuming item results are z-scores
-	// weighted result by distance
-	buyFreq := float64(0)
-	sellFreq := float64(0)
-	neitherFreq := float64(0)
-	for _, res := range results {
-		if res.Item.Result > 1 {
-			buyFreq += 1 / res.Distance
-		} else if res.Item.Result < 1 {
-			sellFreq += 1 / res.Distance
-		} else {
-			neitherFreq += 1 / res.Distance
+func (model *CompressionModel) AddMarketData(data []record.Market) error {
+	var batch []CompressionItem
+	worker := func() (func(i int, s splicer.Splice) error, func(), error) {
+		c, err := model.newCompressor(libdeflate.MaxCompressionLevel)
+		if err != nil {
+			return nil, nil, err
 		}
+		convert := func(i int, s splicer.Splice) error {
+			base := newItem(s)
+			item, err := CompressModelData(c, base.Data, model.EncodingType)
+			if err != nil {
+				return err
+			}
+			item.Item = base
+			batch[i] = *item
+			return nil
+		}
+		return convert, c.Close, nil
 	}
-
-	if buyFreq > sellFreq && buyFreq > neitherFreq {
-		return 1, nil
-	} else if sellFreq > buyFreq && sellFreq > neitherFreq {
-		return -1, nil
-	} else {
-		return 0, nil
+	startBatch := func(size int) {
+		batch = make([]CompressionItem, size)
+	}
+	endBatch := func() {
+		model.Items = append(model.Items, batch...)
 	}
+	return streamSplices(data, model.SpliceOptions, model.BuildOptions, worker, startBatch, endBatch)
 }
 
-// GetClosestNeighbours expects data to come pre-normalised
-func (model *CompressionModel) GetClosestNeighbours(observation record.Model, nearestN int) ([]*Neighbour, error) {
+func (model *CompressionModel) Predict(observation record.Model, opts PredictionOpts) (*Prediction, error) {
+	return predictFromModel(model, observation, opts)
+}
 
-	c, err := libdeflate.NewCompressorLevel(libdeflate.MaxCompressionLevel)
+func (model *CompressionModel) Neighbours(observation record.Model, nearestN int) ([]*Neighbour, error) {
+	if nearestN < 1 {
+		return nil, errors.New("number of neighbours must be positive")
+	}
+	c, err := model.newCompressor(libdeflate.MaxCompressionLevel)
 	if err != nil {
 		return nil, err
 	}
@@ -169,7 +159,7 @@ func (model *CompressionModel) GetClosestNeighbours(observation record.Model, ne
 	}
 	Cx1 := float64(compressedObservation.CompressedSize)
 	results := make([]*Neighbour, nearestN)
-	for _, item := range model.Items {
+	for index, item := range model.Items {
 		item := item
 
 		combined, err := record.CombineModels(item.Data, observation, model.CombineStrategy)
@@ -185,26 +175,13 @@ func (model *CompressionModel) GetClosestNeighbours(observation record.Model, ne
 		Cx2 := float64(item.CompressedSize)
 		distance := (Cx1x2 - math.Min(Cx1, Cx2)) / math.Max(Cx1, Cx2)
 
-		insertIndex := -1
-		for i, res := range results {
-			i := i
-			if res == nil {
-				insertIndex = i
-				break
-			}
-			if res.Distance > distance {
-				insertIndex = i
-				break
-			}
-		}
-		if insertIndex != -1 {
-			results[insertIndex] = &Neighbour{
-				Distance: distance,
-				Item:     item,
-			}
-		}
+		insertNeighbour(results, &Neighbour{
+			Distance: distance,
+			Index:    index,
+			Item:     item.Item,
+		})
 	}
-	return results, nil
+	return trimNeighbours(results), nil
 }
 
 func DistanceBetween(c libdeflate.Compressor, x1 CompressionItem, x2 CompressionItem, encodingType CompressionEncodingType, combineStrat record.CombineStrategy) (float64, error) {
@@ -226,40 +203,24 @@ func DistanceBetween(c libdeflate.Compressor, x1 CompressionItem, x2 Compression
 	return (Cx1x2 - math.Min(Cx1, Cx2)) / math.Max(Cx1, Cx2), nil
 }
 
-func (model *CompressionModel) DistanceMap() ([][]float64, error) {
-	// if we already have a distance map, return it
-	if model.CachedDistanceMap != nil && len(model.CachedDistanceMap) == len(model.Items) {
-		return model.CachedDistanceMap, nil
+func (model *CompressionModel) DistanceMap() (*DistanceMatrix, error) {
+	keys := make([]uint64, len(model.Items))
+	for i, item := range model.Items {
+		keys[i] = ItemKey(item.Data)
 	}
 
-	res := make([][]float64, len(model.Items))
-	for i := range model.Items {
-		res[i] = make([]float64, len(model.Items))
-	}
-	var wg sync.WaitGroup
-	for i, itemI := range model.Items {
-		wg.Add(1)
-		go func(i int, itemI CompressionItem) {
-			defer wg.Done()
-			c, err := libdeflate.NewCompressor()
-			if err != nil {
-				panic(err)
-			}
-			defer c.Close()
-			for j, itemJ := range model.Items[i:] {
-				canonicalJ := j + i
-				distance, err := DistanceBetween(c, itemI, itemJ, model.EncodingType, model.CombineStrategy)
-				if err != nil {
-					panic(err)
-				}
-				res[i][canonicalJ] = distance
-				res[canonicalJ][i] = distance
-			}
-		}(i, itemI)
+	worker := func() (DistanceFunc, func(), error) {
+		c, err := model.newCompressor(libdeflate.DefaultCompressionLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		distance := func(i, j int) (float64, error) {
+			return DistanceBetween(c, model.Items[i], model.Items[j], model.EncodingType, model.CombineStrategy)
+		}
+		return distance, c.Close, nil
 	}
-	wg.Wait()
-	model.CachedDistanceMap = res
-	return res, nil
+
+	return model.distanceMap(keys, worker)
 }
 
 func (model *CompressionModel) SizeResultBuckets() map[int][]float64 {
@@ -271,20 +232,28 @@ func (model *CompressionModel) SizeResultBuckets() map[int][]float64 {
 	return buckets
 }
 
+func (model *CompressionModel) Options() splicer.SpliceOptions {
+	return model.SpliceOptions
+}
+
+func (model *CompressionModel) Len() int {
+	return len(model.Items)
+}
+
+func (model *CompressionModel) Item(i int) Item {
+	return model.Items[i].Item
+}
+
+func (model *CompressionModel) ItemResult(i int) float64 {
+	return model.Items[i].Result
+}
+
 func (model *CompressionModel) SaveToFile(file string) error {
-	modelFile, err := os.Create(file)
-	defer modelFile.Close()
-	if err != nil {
-		return err
-	}
-	gzipWriter := gzip.NewWriter(modelFile)
-	encoder := json.NewEncoder(gzipWriter)
-	err = encoder.Encode(model)
+	err := model.saveDistanceMap(file)
 	if err != nil {
 		return err
 	}
-	err = gzipWriter.Flush()
-	return nil
+	return saveModelFile(file, model)
 }
 
 func EncodeToCharVarLength(b *strings.Builder, records []float64) {
@@ -319,20 +288,24 @@ func EncodeToCharVarLength(b *strings.Builder, records []float64) {
 }
 
 func EncodeToRomanNumerals(b *strings.Builder, records []float64) {
-	conversions := map[int]string{
-		1000: "M",
-		900:  "CM",
-		500:  "D",
-		400:  "CD",
-		100:  "C",
-		90:   "XC",
-		50:   "L",
-		40:   "XL",
-		10:   "X",
-		9:    "IX",
-		5:    "V",
-		4:    "IV",
-		1:    "I",
+	// ordered largest first, numerals are built greedily
+	conversions := []struct {
+		value int
+		digit string
+	}{
+		{1000, "M"},
+		{900, "CM"},
+		{500, "D"},
+		{400, "CD"},
+		{100, "C"},
+		{90, "XC"},
+		{50, "L"},
+		{40, "XL"},
+		{10, "X"},
+		{9, "IX"},
+		{5, "V"},
+		{4, "IV"},
+		{1, "I"},
 	}
 	convertFloat := func(f float64) {
 		if f < 0 {
@@ -350,10 +323,10 @@ func EncodeToRomanNumerals(b *strings.Builder, records []float64) {
 			return
 		}
 
-		for romanVal, romanDigit := range conversions {
-			for val >= romanVal {
-				b.WriteString(romanDigit)
-				val -= romanVal
+		for _, conversion := range conversions {
+			for val >= conversion.value {
+				b.WriteString(conversion.digit)
+				val -= conversion.value
 			}
 		}
 	}
@@ -441,7 +414,8 @@ func GetCompressedLength(c libdeflate.Compressor, data record.Model, encodingTyp
 	calcSize := func(input []float64) (int, error) {
 		b.Reset()
 		encodingFunc(&b, input)
-		var compBuffer = make([]byte, b.Len(), b.Len())
+		// short or incompressible input can grow when compressed
+		var compBuffer = make([]byte, c.WorstCaseCompressedSize(b.Len(), libdeflate.ModeGzip))
 		size, _, err := c.Compress([]byte(b.String()), compBuffer, libdeflate.ModeGzip)
 		if err != nil {
 			return -1, err
@@ -485,7 +459,7 @@ func CompressModelData(c libdeflate.Compressor, m record.Model, encodingType Com
 		return nil, err
 	}
 	return &CompressionItem{
-		Data:           m,
+		Item:           Item{Data: m},
 		CompressedSize: compressed,
 	}, nil
 }
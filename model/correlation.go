@@ -0,0 +1,52 @@
+package model
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func NewCorrelationModel(spliceOpts splicer.SpliceOptions) *VectorModel {
+	return newVectorModel(Correlation, spliceOpts)
+}
+
+// correlationDistanceBetween returns 1 - the Pearson correlation of the two
+// series, so it ranges from 0 for perfectly correlated to 2 for perfectly
+// anti-correlated series
+func correlationDistanceBetween(x1s, x2s []float64) (float64, error) {
+	if len(x1s) != len(x2s) {
+		return math.MaxFloat64, errors.New("vectors must have the same length")
+	}
+	if len(x1s) == 0 {
+		return 0, nil
+	}
+
+	N := float64(len(x1s))
+	var mean1, mean2 float64
+	for i := range x1s {
+		mean1 += x1s[i]
+		mean2 += x2s[i]
+	}
+	mean1 /= N
+	mean2 /= N
+
+	var covariance, var1, var2 float64
+	for i := range x1s {
+		d1 := x1s[i] - mean1
+		d2 := x2s[i] - mean2
+		covariance += d1 * d2
+		var1 += d1 * d1
+		var2 += d2 * d2
+	}
+	if var1 == 0 || var2 == 0 {
+		// correlation is undefined for a flat series, treat two flat series
+		// as alike and a flat series as unrelated to anything else
+		if var1 == var2 {
+			return 0, nil
+		}
+		return 1, nil
+	}
+
+	return 1 - covariance/math.Sqrt(var1*var2), nil
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCosineDistanceBetween(t *testing.T) {
+	runTest := func(t *testing.T, x1s, x2s []float64, expected float64) {
+		distance, err := cosineDistanceBetween(x1s, x2s)
+		assert.NoError(t, err)
+		assert.InDelta(t, expected, distance, 1e-9)
+	}
+
+	t.Run("same direction", func(t *testing.T) {
+		runTest(t, []float64{1, 2, 3}, []float64{2, 4, 6}, 0)
+	})
+
+	t.Run("orthogonal", func(t *testing.T) {
+		runTest(t, []float64{1, 0}, []float64{0, 1}, 1)
+	})
+
+	t.Run("opposite direction", func(t *testing.T) {
+		runTest(t, []float64{1, -2}, []float64{-1, 2}, 2)
+	})
+
+	t.Run("zero vectors", func(t *testing.T) {
+		runTest(t, []float64{0, 0}, []float64{0, 0}, 0)
+		runTest(t, []float64{0, 0}, []float64{1, 0}, 1)
+	})
+
+	t.Run("unequal lengths", func(t *testing.T) {
+		_, err := cosineDistanceBetween([]float64{1}, []float64{1, 2})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,313 @@
+package model
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+// DistanceFunc returns the distance between the items at index i and j
+type DistanceFunc func(i, j int) (float64, error)
+
+// DistanceWorker creates a DistanceFunc for a single goroutine, along
+// with a function to release anything it holds on to
+type DistanceWorker func() (DistanceFunc, func(), error)
+
+// ItemKey fingerprints an item's data, so cached distances can be
+// matched back to items after the model has been modified
+func ItemKey(data record.Model) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	writeSeries := func(series []float64) {
+		binary.LittleEndian.PutUint64(buf, uint64(len(series)))
+		h.Write(buf)
+		for _, v := range series {
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(v))
+			h.Write(buf)
+		}
+	}
+	writeSeries(data.Opens)
+	writeSeries(data.Highs)
+	writeSeries(data.Lows)
+	writeSeries(data.Closes)
+	writeSeries(data.Volumes)
+	writeSeries(data.VWAPs)
+	return h.Sum64()
+}
+
+// DistanceCache tracks the sidecar file holding a model's distance matrix,
+// and the keys of the items it was computed for
+type DistanceCache struct {
+	Keys []uint64 `json:"distance_map_keys"`
+	File string   `json:"distance_map_file"`
+
+	modelPath string
+	matrix    *DistanceMatrix
+	temporary bool
+}
+
+func (c *DistanceCache) setModelPath(file string) {
+	c.modelPath = file
+}
+
+func (c *DistanceCache) sidecarPath() string {
+	if c.matrix != nil {
+		return c.matrix.Path()
+	}
+	if c.File == "" || c.modelPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(c.modelPath), c.File)
+}
+
+func (c *DistanceCache) distanceMap(keys []uint64, worker DistanceWorker) (*DistanceMatrix, error) {
+	if c.matrix == nil {
+		if existing := c.sidecarPath(); existing != "" {
+			matrix, err := OpenDistanceMatrix(existing)
+			if err == nil {
+				c.matrix = matrix
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	previousPath := c.sidecarPath()
+	path := previousPath
+	temporary := c.temporary
+	if c.modelPath != "" {
+		path = c.modelPath + ".dist"
+		temporary = false
+	}
+	if path == "" {
+		tmp, err := os.CreateTemp("", "silly-trader-*.dist")
+		if err != nil {
+			return nil, err
+		}
+		tmp.Close()
+		path = tmp.Name()
+		temporary = true
+	}
+
+	matrix, err := UpdateDistanceMatrix(c.matrix, c.Keys, keys, path, worker)
+	if err != nil {
+		return nil, err
+	}
+	if c.temporary && previousPath != path {
+		os.Remove(previousPath)
+	}
+	c.matrix = matrix
+	c.temporary = temporary
+	c.Keys = keys
+	return matrix, nil
+}
+
+// DiscardDistanceMap closes the distance matrix, deleting it if it was
+// never saved alongside a model file
+func (c *DistanceCache) DiscardDistanceMap() error {
+	if c.matrix == nil {
+		return nil
+	}
+	path := c.matrix.Path()
+	err := c.matrix.Close()
+	c.matrix = nil
+	if err != nil {
+		return err
+	}
+	if c.temporary {
+		c.temporary = false
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// saveDistanceMap moves the sidecar next to the model file being saved
+func (c *DistanceCache) saveDistanceMap(modelFile string) error {
+	source := c.sidecarPath()
+	if source == "" {
+		c.File = ""
+		c.setModelPath(modelFile)
+		return nil
+	}
+	target := modelFile + ".dist"
+	if source != target {
+		if c.matrix != nil {
+			if err := c.matrix.Close(); err != nil {
+				return err
+			}
+			c.matrix = nil
+		}
+		if err := copyFile(source, target); err != nil {
+			return err
+		}
+		if c.temporary {
+			os.Remove(source)
+			c.temporary = false
+		}
+	}
+	c.File = filepath.Base(target)
+	c.setModelPath(modelFile)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// UpdateDistanceMatrix writes the distance matrix for items identified by
+// keys to path, reusing every distance in cached whose pair of items is
+// still present. Only pairs involving items that are new to the model are
+// computed, and when items have only been appended the new columns are
+// appended to the existing file in place. Removed or reordered items are
+// remapped into a new file instead of leaving the cache silently stale.
+// A cache without keys can't be verified and is discarded.
+func UpdateDistanceMatrix(cached *DistanceMatrix, cachedKeys, keys []uint64, path string, worker DistanceWorker) (*DistanceMatrix, error) {
+	if cached != nil && cached.Len() < len(cachedKeys) {
+		cached = nil
+	}
+	previous := make([]int, len(keys))
+	oldIndex := make(map[uint64]int, len(cachedKeys))
+	if cached != nil {
+		for i, key := range cachedKeys {
+			oldIndex[key] = i
+		}
+	}
+	// number of leading items that are exactly where the cache has them
+	inPlace := 0
+	for i, key := range keys {
+		prev, found := oldIndex[key]
+		if !found {
+			prev = -1
+		}
+		previous[i] = prev
+		if prev == i && inPlace == i {
+			inPlace++
+		}
+	}
+	if cached != nil && inPlace == len(keys) && len(keys) == len(cachedKeys) && cached.Path() == path {
+		return cached, nil
+	}
+
+	appending := cached != nil && cached.Path() == path && inPlace == len(cachedKeys)
+	var writer *distanceMatrixWriter
+	var err error
+	from := 0
+	target := path
+	if appending {
+		from = inPlace
+		writer, err = appendDistanceMatrix(path)
+	} else {
+		target = path + ".tmp"
+		writer, err = createDistanceMatrix(target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeDistanceColumns(writer, from, previous, cached, worker); err != nil {
+		writer.abort()
+		return nil, err
+	}
+	if err := writer.finish(len(keys)); err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if err := cached.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if target != path {
+		if err := os.Rename(target, path); err != nil {
+			return nil, err
+		}
+	}
+	return OpenDistanceMatrix(path)
+}
+
+func writeDistanceColumns(writer *distanceMatrixWriter, from int, previous []int, cached *DistanceMatrix, worker DistanceWorker) error {
+	columns := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var failed int32
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			atomic.StoreInt32(&failed, 1)
+		})
+	}
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var distance DistanceFunc
+			var column []float64
+			for j := range columns {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				column = column[:0]
+				for i := 0; i <= j; i++ {
+					prevI, prevJ := previous[i], previous[j]
+					if prevI >= 0 && prevJ >= 0 {
+						d, err := cached.At(prevI, prevJ)
+						if err != nil {
+							setErr(err)
+							break
+						}
+						column = append(column, d)
+						continue
+					}
+					if distance == nil {
+						var release func()
+						var err error
+						distance, release, err = worker()
+						if err != nil {
+							setErr(err)
+							break
+						}
+						defer release()
+					}
+					d, err := distance(i, j)
+					if err != nil {
+						setErr(err)
+						break
+					}
+					column = append(column, d)
+				}
+				if len(column) == j+1 {
+					if err := writer.writeColumn(j, column); err != nil {
+						setErr(err)
+					}
+				}
+			}
+		}()
+	}
+	for j := from; j < len(previous); j++ {
+		columns <- j
+	}
+	close(columns)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateDistanceMatrix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.dist")
+	values := []float64{1, 4, 9, 16, 25}
+	countingWorker := func(items []float64, computed *int64) DistanceWorker {
+		return func() (DistanceFunc, func(), error) {
+			distance := func(i, j int) (float64, error) {
+				atomic.AddInt64(computed, 1)
+				return math.Abs(items[i] - items[j]), nil
+			}
+			return distance, func() {}, nil
+		}
+	}
+	keysOf := func(items []float64) []uint64 {
+		keys := make([]uint64, len(items))
+		for i, v := range items {
+			keys[i] = math.Float64bits(v)
+		}
+		return keys
+	}
+	assertMap := func(t *testing.T, items []float64, res *DistanceMatrix) {
+		assert.Equal(t, len(items), res.Len())
+		for i := range items {
+			for j := range items {
+				d, err := res.At(i, j)
+				assert.NoError(t, err)
+				assert.Equal(t, math.Abs(items[i]-items[j]), d)
+			}
+		}
+	}
+
+	var computed int64
+	initial := values[:3]
+	cached, err := UpdateDistanceMatrix(nil, nil, keysOf(initial), path, countingWorker(initial, &computed))
+	assert.NoError(t, err)
+	assertMap(t, initial, cached)
+	assert.Equal(t, int64(6), computed)
+
+	t.Run("unchanged items reuse the cache", func(t *testing.T) {
+		var computed int64
+		res, err := UpdateDistanceMatrix(cached, keysOf(initial), keysOf(initial), path, countingWorker(initial, &computed))
+		assert.NoError(t, err)
+		assertMap(t, initial, res)
+		assert.Equal(t, int64(0), computed)
+	})
+
+	t.Run("appended items only compute new pairs", func(t *testing.T) {
+		var computed int64
+		res, err := UpdateDistanceMatrix(cached, keysOf(initial), keysOf(values), path, countingWorker(values, &computed))
+		assert.NoError(t, err)
+		assertMap(t, values, res)
+		// items 0-2 against 3-4, plus the upper triangle of items 3-4
+		assert.Equal(t, int64(3*2+3), computed)
+		cached = res
+	})
+
+	t.Run("removed and reordered items are remapped", func(t *testing.T) {
+		var computed int64
+		reordered := []float64{9, 1}
+		res, err := UpdateDistanceMatrix(cached, keysOf(values), keysOf(reordered), path, countingWorker(reordered, &computed))
+		assert.NoError(t, err)
+		assertMap(t, reordered, res)
+		assert.Equal(t, int64(0), computed)
+		cached = res
+	})
+
+	t.Run("cache without keys is recomputed", func(t *testing.T) {
+		var computed int64
+		res, err := UpdateDistanceMatrix(cached, nil, keysOf(initial), path, countingWorker(initial, &computed))
+		assert.NoError(t, err)
+		assertMap(t, initial, res)
+		assert.Equal(t, int64(6), computed)
+		cached = res
+	})
+
+	t.Run("matrix is readable from the sidecar file", func(t *testing.T) {
+		assert.NoError(t, cached.Close())
+		reopened, err := OpenDistanceMatrix(path)
+		assert.NoError(t, err)
+		assertMap(t, initial, reopened)
+		assert.NoError(t, reopened.Close())
+	})
+}
+
+func TestDistanceMatrixReadError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.dist")
+	matrix, err := UpdateDistanceMatrix(nil, nil, []uint64{1, 2}, path, func() (DistanceFunc, func(), error) {
+		return func(i, j int) (float64, error) {
+			return 1, nil
+		}, func() {}, nil
+	})
+	assert.NoError(t, err)
+
+	// read through a closed file the way matrices are without mmap
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	unmapped := &DistanceMatrix{path: path, n: matrix.Len(), file: file}
+	assert.NoError(t, matrix.Close())
+
+	_, err = unmapped.At(0, 1)
+	assert.Error(t, err)
+	_, err = unmapped.Row(0, nil)
+	assert.Error(t, err)
+}
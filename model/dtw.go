@@ -0,0 +1,59 @@
+package model
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// NewDTWModel creates a dynamic time warping model, where window is the
+// Sakoe-Chiba band width in bars that a point may be warped by
+func NewDTWModel(spliceOpts splicer.SpliceOptions, window int) *VectorModel {
+	m := newVectorModel(DTW, spliceOpts)
+	m.DTWWindow = window
+	return m
+}
+
+// dtwDistanceBetween finds the cheapest alignment between two series,
+// only matching points that are at most window bars apart. A window of
+// zero reduces to the euclidean distance, a negative window is unbounded.
+func dtwDistanceBetween(x1s, x2s []float64, window int) (float64, error) {
+	if len(x1s) != len(x2s) {
+		return math.MaxFloat64, errors.New("vectors must have the same length")
+	}
+	N := len(x1s)
+	if window < 0 || window > N {
+		window = N
+	}
+
+	// only two rows of the cost matrix are needed at any time
+	prev := make([]float64, N+1)
+	cur := make([]float64, N+1)
+	for j := range prev {
+		prev[j] = math.Inf(1)
+	}
+	prev[0] = 0
+
+	for i := 1; i <= N; i++ {
+		for j := range cur {
+			cur[j] = math.Inf(1)
+		}
+		from := i - window
+		if from < 1 {
+			from = 1
+		}
+		to := i + window
+		if to > N {
+			to = N
+		}
+		for j := from; j <= to; j++ {
+			diff := x1s[i-1] - x2s[j-1]
+			cost := diff * diff
+			cur[j] = cost + math.Min(prev[j-1], math.Min(prev[j], cur[j-1]))
+		}
+		prev, cur = cur, prev
+	}
+
+	return math.Sqrt(prev[N]), nil
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func NewEuclideanModel(spliceOpts splicer.SpliceOptions) *VectorModel {
+	return newVectorModel(Euclidean, spliceOpts)
+}
+
+func euclideanDistanceBetween(x1s, x2s []float64) (float64, error) {
+	if len(x1s) != len(x2s) {
+		return math.MaxFloat64, errors.New("vectors must have the same length")
+	}
+
+	var sum float64
+	for i := range x1s {
+		diff := x1s[i] - x2s[i]
+		sum += diff * diff
+	}
+	return math.Sqrt(sum), nil
+}
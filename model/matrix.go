@@ -0,0 +1,172 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// distance matrices are stored as a header followed by the upper triangle
+// of the matrix in column order as little endian float32s, i.e. column j
+// holds distances to rows 0..j. Appending an item to the model appends a
+// column to the end of the file without moving anything already written.
+const (
+	distanceMatrixMagic      = "SDM1"
+	distanceMatrixHeaderSize = 16
+)
+
+type DistanceMatrix struct {
+	path string
+	n    int
+	file *os.File
+	data []byte
+}
+
+func triangleOffset(i, j int) int64 {
+	if i > j {
+		i, j = j, i
+	}
+	index := int64(j)*int64(j+1)/2 + int64(i)
+	return distanceMatrixHeaderSize + index*4
+}
+
+func OpenDistanceMatrix(path string) (*DistanceMatrix, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, distanceMatrixHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if string(header[:4]) != distanceMatrixMagic {
+		file.Close()
+		return nil, errors.New("file is not a distance matrix")
+	}
+	n := int(binary.LittleEndian.Uint64(header[8:]))
+	size := triangleOffset(0, n)
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if stat.Size() < size {
+		file.Close()
+		return nil, errors.New("distance matrix file is truncated")
+	}
+	matrix := &DistanceMatrix{
+		path: path,
+		n:    n,
+		file: file,
+	}
+	if n > 0 {
+		matrix.data, err = mapFile(file, int(size))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return matrix, nil
+}
+
+func (m *DistanceMatrix) Len() int {
+	return m.n
+}
+
+func (m *DistanceMatrix) Path() string {
+	return m.path
+}
+
+// At returns the distance between items i and j, it can only fail when
+// the matrix isn't mapped into memory and has to be read from the file
+func (m *DistanceMatrix) At(i, j int) (float64, error) {
+	offset := triangleOffset(i, j)
+	if m.data != nil {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(m.data[offset:]))), nil
+	}
+	buf := make([]byte, 4)
+	if _, err := m.file.ReadAt(buf, offset); err != nil {
+		return 0, err
+	}
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))), nil
+}
+
+// Row fills dst with the distances from item i to every other item,
+// reusing dst's backing array where possible
+func (m *DistanceMatrix) Row(i int, dst []float64) ([]float64, error) {
+	if cap(dst) < m.n {
+		dst = make([]float64, m.n)
+	}
+	dst = dst[:m.n]
+	for j := range dst {
+		d, err := m.At(i, j)
+		if err != nil {
+			return dst, err
+		}
+		dst[j] = d
+	}
+	return dst, nil
+}
+
+func (m *DistanceMatrix) Close() error {
+	if m.data != nil {
+		if err := unmapFile(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	return m.file.Close()
+}
+
+type distanceMatrixWriter struct {
+	file *os.File
+}
+
+func createDistanceMatrix(path string) (*distanceMatrixWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &distanceMatrixWriter{file: file}, nil
+}
+
+func appendDistanceMatrix(path string) (*distanceMatrixWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &distanceMatrixWriter{file: file}, nil
+}
+
+// writeColumn writes the distances from item j to items 0..j, it is safe
+// to call concurrently for different columns
+func (w *distanceMatrixWriter) writeColumn(j int, column []float64) error {
+	buf := make([]byte, 4*len(column))
+	for i, dist := range column {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(dist)))
+	}
+	_, err := w.file.WriteAt(buf, triangleOffset(0, j))
+	return err
+}
+
+func (w *distanceMatrixWriter) finish(n int) error {
+	header := make([]byte, distanceMatrixHeaderSize)
+	copy(header, distanceMatrixMagic)
+	binary.LittleEndian.PutUint64(header[8:], uint64(n))
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Truncate(triangleOffset(0, n)); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *distanceMatrixWriter) abort() {
+	w.file.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package model
+
+import "os"
+
+// without mmap the matrix is read through the file on demand
+
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -1,10 +1,16 @@
 package model
 
 import (
-	"fmt"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
 	"math"
+	"os"
+	"sort"
 
 	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
 )
 
 type ModelType string
@@ -12,65 +18,329 @@ type ModelType string
 const (
 	Compression ModelType = "compression"
 	Cosine      ModelType = "cosine"
+	Euclidean   ModelType = "euclidean"
+	Correlation ModelType = "correlation"
+	DTW         ModelType = "dtw"
 )
 
+func ToModelType(input string) (ModelType, error) {
+	switch input {
+	case string(Compression):
+		return Compression, nil
+	case string(Cosine):
+		return Cosine, nil
+	case string(Euclidean):
+		return Euclidean, nil
+	case string(Correlation):
+		return Correlation, nil
+	case string(DTW):
+		return DTW, nil
+	}
+	return Compression, errors.New("invalid model type specified")
+}
+
+// Item is a single splice of market data held by a model, along with
+// the result that followed it
+type Item struct {
+	Data   record.Model `json:"model"`
+	Result float64      `json:"result"`
+	// Results holds the result at each of the splice options' horizons
+	Results []float64 `json:"results"`
+	// StartTime and EndTime span the splice, and ResultTime is when the
+	// last of its results was known
+	StartTime  int64 `json:"start_time"`
+	EndTime    int64 `json:"end_time"`
+	ResultTime int64 `json:"result_time"`
+}
+
+func newItem(s splicer.Splice) Item {
+	return Item{
+		Data:       record.MarketToModel(s.Data),
+		Result:     s.Result,
+		Results:    s.Results,
+		StartTime:  s.StartTime,
+		EndTime:    s.EndTime,
+		ResultTime: s.ResultTime,
+	}
+}
+
+// Overlaps returns whether any of the data either item was built from or
+// labelled with is shared, or would be if both lasted window longer
+func (item Item) Overlaps(other Item, window int64) bool {
+	return item.StartTime <= other.ResultTime+window && other.StartTime <= item.ResultTime+window
+}
+
+// ResultAt returns the result at a horizon index, items from before
+// multiple horizons were recorded only have their primary result
+func (item Item) ResultAt(horizonIndex int) float64 {
+	if horizonIndex < len(item.Results) {
+		return item.Results[horizonIndex]
+	}
+	return item.Result
+}
+
+type Neighbour struct {
+	Distance float64
+	Index    int
+	Item     Item
+}
+
+// insertNeighbour places candidate into results, which is kept sorted by
+// ascending distance with nil entries at the end while not yet full
+func insertNeighbour(results []*Neighbour, candidate *Neighbour) {
+	insertIndex := -1
+	for i, res := range results {
+		if res == nil || res.Distance > candidate.Distance {
+			insertIndex = i
+			break
+		}
+	}
+	if insertIndex == -1 {
+		return
+	}
+	copy(results[insertIndex+1:], results[insertIndex:len(results)-1])
+	results[insertIndex] = candidate
+}
+
+// trimNeighbours drops the unfilled entries when a model has fewer items
+// than the number of neighbours requested
+func trimNeighbours(results []*Neighbour) []*Neighbour {
+	for i, res := range results {
+		if res == nil {
+			return results[:i]
+		}
+	}
+	return results
+}
+
 type Model interface {
 	SaveToFile(file string) error
 	AddMarketData(data []record.Market) error
 	SizeResultBuckets() map[int][]float64
-	DistanceMap() ([][]float64, error)
+	DistanceMap() (*DistanceMatrix, error)
+	// Neighbours expects observation to come pre-normalised, and returns
+	// up to k items ordered by ascending distance
+	Neighbours(observation record.Model, k int) ([]*Neighbour, error)
+	Predict(observation record.Model, opts PredictionOpts) (*Prediction, error)
+	Options() splicer.SpliceOptions
+	Len() int
+	Item(i int) Item
+	ItemResult(i int) float64
+	SetBuildOptions(opts BuildOptions)
 }
 
-func DistanceVarianceHistogram(model Model, bucketSize float64) (map[int]float64, error) {
-	distanceMap, err := model.DistanceMap()
+// LoadModel loads a model of any type, models saved before they were
+// tagged with a type are compression models. The file is only read once,
+// and decoded as whichever type it is tagged with.
+func LoadModel(file string) (Model, error) {
+	data, err := readModelFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Type ModelType `json:"model_type"`
+	}
+	err = json.Unmarshal(data, &header)
 	if err != nil {
 		return nil, err
 	}
+	switch header.Type {
+	case "", Compression:
+		return decodeCompressionModel(file, data)
+	case Cosine, Euclidean, Correlation, DTW:
+		return decodeVectorModel(file, data)
+	}
+	return nil, errors.New("invalid model type specified")
+}
 
-	resultBuckets := make(map[int][]float64)
-	largestBucket := math.MinInt
-	smallestBucket := math.MaxInt
-	for i, js := range distanceMap {
-		for j, dist := range js {
-			if i == j {
-				//continue
-			}
-			destinationBucket := int(math.Floor(dist / bucketSize))
-			if destinationBucket > largestBucket {
-				largestBucket = destinationBucket
-			}
-			if destinationBucket < smallestBucket {
-				smallestBucket = destinationBucket
-			}
+// readModelFile returns the decompressed contents of a saved model
+func readModelFile(file string) ([]byte, error) {
+	modelFile, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer modelFile.Close()
+	reader, err := gzip.NewReader(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
 
-			resultBuckets[destinationBucket] = append(resultBuckets[destinationBucket], math.Abs(model.Items[i].Result-model.Items[j].Result))
-		}
+func saveModelFile(file string, model interface{}) error {
+	modelFile, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer modelFile.Close()
+	gzipWriter := gzip.NewWriter(modelFile)
+	encoder := json.NewEncoder(gzipWriter)
+	err = encoder.Encode(model)
+	if err != nil {
+		return err
 	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+	return modelFile.Close()
+}
 
-	fmt.Printf("Largest bucket: %d\n", largestBucket)
+type DistanceBucketing string
 
-	varianceResults := make(map[int]float64)
-	for bucket := smallestBucket; bucket <= largestBucket; bucket++ {
-		results, hasBucket := resultBuckets[bucket]
-		if !hasBucket {
-			varianceResults[bucket] = float64(0)
-			continue
-		}
-		N := float64(len(results))
-		sum := float64(0)
-		for _, result := range results {
-			sum += result
+const (
+	FixedBuckets    DistanceBucketing = "fixed"
+	QuantileBuckets DistanceBucketing = "quantile"
+)
+
+func ToDistanceBucketing(input string) (DistanceBucketing, error) {
+	switch input {
+	case string(FixedBuckets):
+		return FixedBuckets, nil
+	case string(QuantileBuckets):
+		return QuantileBuckets, nil
+	}
+	return FixedBuckets, errors.New("invalid distance bucketing specified")
+}
+
+type DistanceVarianceOpts struct {
+	Bucketing DistanceBucketing
+	// BucketSize is the width of fixed buckets
+	BucketSize float64
+	// Buckets is how many quantile buckets pairs are split into, each
+	// holding close to the same number of pairs
+	Buckets int
+	// ExcludeSelf drops each item's distance to itself
+	ExcludeSelf bool
+	// ExcludeOverlapping drops pairs of items that share any data, treating
+	// items as lasting OverlapWindow longer
+	ExcludeOverlapping bool
+	OverlapWindow      int64
+	HorizonIndex       int
+}
+
+// DistanceBucket summarises the absolute difference between the results
+// of the pairs of items whose distance is between Lower and Upper
+type DistanceBucket struct {
+	Lower    float64 `json:"lower"`
+	Upper    float64 `json:"upper"`
+	Count    int     `json:"count"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+}
+
+func (bucket *DistanceBucket) summarise(differences []float64) {
+	bucket.Count = len(differences)
+	if bucket.Count == 0 {
+		return
+	}
+	for _, d := range differences {
+		bucket.Mean += d
+	}
+	bucket.Mean /= float64(bucket.Count)
+	for _, d := range differences {
+		bucket.Variance += math.Pow(d-bucket.Mean, 2)
+	}
+	bucket.Variance /= float64(bucket.Count)
+}
+
+// DistanceVarianceHistogram buckets every pair of items by their distance,
+// if closer pairs have more similar results the mean difference between
+// their results should rise with distance
+func DistanceVarianceHistogram(m Model, opts DistanceVarianceOpts) ([]DistanceBucket, error) {
+	switch opts.Bucketing {
+	case FixedBuckets:
+		if opts.BucketSize <= 0 {
+			return nil, errors.New("bucket size must be positive")
 		}
-		mean := sum / N
-		variance := float64(0)
-		for _, result := range results {
-			variance += math.Pow(result-mean, 2)
+	case QuantileBuckets:
+		if opts.Buckets < 1 {
+			return nil, errors.New("number of buckets must be positive")
 		}
-		variance /= N
-		varianceResults[bucket] = variance
+	default:
+		return nil, errors.New("invalid distance bucketing specified")
+	}
+	distanceMap, err := m.DistanceMap()
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("varianceResults: %+v\n", varianceResults)
+	type pair struct {
+		distance   float64
+		difference float64
+	}
+	items := make([]Item, m.Len())
+	for i := range items {
+		items[i] = m.Item(i)
+	}
+	var pairs []pair
+	var row []float64
+	for i := range items {
+		row, err = distanceMap.Row(i, row)
+		if err != nil {
+			return nil, err
+		}
+		from := i + 1
+		if !opts.ExcludeSelf {
+			from = i
+		}
+		// the matrix is symmetric, so each pair is only counted once
+		for j := from; j < len(items); j++ {
+			if opts.ExcludeOverlapping && i != j && items[i].Overlaps(items[j], opts.OverlapWindow) {
+				continue
+			}
+			pairs = append(pairs, pair{
+				distance:   row[j],
+				difference: math.Abs(items[i].ResultAt(opts.HorizonIndex) - items[j].ResultAt(opts.HorizonIndex)),
+			})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("no pairs of items left to bucket")
+	}
+	sort.Slice(pairs, func(a, b int) bool {
+		return pairs[a].distance < pairs[b].distance
+	})
 
-	return varianceResults, nil
+	var buckets []DistanceBucket
+	differences := make([]float64, 0, len(pairs))
+	switch opts.Bucketing {
+	case QuantileBuckets:
+		n := opts.Buckets
+		if n > len(pairs) {
+			n = len(pairs)
+		}
+		for b := 0; b < n; b++ {
+			from, to := b*len(pairs)/n, (b+1)*len(pairs)/n
+			differences = differences[:0]
+			for _, p := range pairs[from:to] {
+				differences = append(differences, p.difference)
+			}
+			bucket := DistanceBucket{
+				Lower: pairs[from].distance,
+				Upper: pairs[to-1].distance,
+			}
+			bucket.summarise(differences)
+			buckets = append(buckets, bucket)
+		}
+	case FixedBuckets:
+		// empty buckets between the closest and furthest pairs are kept
+		first := math.Floor(pairs[0].distance / opts.BucketSize)
+		last := math.Floor(pairs[len(pairs)-1].distance / opts.BucketSize)
+		next := 0
+		for b := first; b <= last; b++ {
+			bucket := DistanceBucket{
+				Lower: b * opts.BucketSize,
+				Upper: (b + 1) * opts.BucketSize,
+			}
+			differences = differences[:0]
+			for next < len(pairs) && math.Floor(pairs[next].distance/opts.BucketSize) <= b {
+				differences = append(differences, pairs[next].difference)
+				next++
+			}
+			bucket.summarise(differences)
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets, nil
 }
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// matrixModel only holds items and their distances
+type matrixModel struct {
+	Model
+	items  []Item
+	matrix *DistanceMatrix
+}
+
+func (m *matrixModel) DistanceMap() (*DistanceMatrix, error) {
+	return m.matrix, nil
+}
+
+func (m *matrixModel) Len() int {
+	return len(m.items)
+}
+
+func (m *matrixModel) Item(i int) Item {
+	return m.items[i]
+}
+
+func TestDistanceVarianceHistogram(t *testing.T) {
+	// items a distance apart equal to the difference of their results
+	results := []float64{0, 1, 2, 4}
+	items := make([]Item, len(results))
+	keys := make([]uint64, len(results))
+	for i, r := range results {
+		items[i] = Item{
+			Result:     r,
+			StartTime:  int64(i * 10),
+			EndTime:    int64(i*10 + 5),
+			ResultTime: int64(i*10 + 5),
+		}
+		keys[i] = uint64(i)
+	}
+	matrix, err := UpdateDistanceMatrix(nil, nil, keys, filepath.Join(t.TempDir(), "model.dist"), func() (DistanceFunc, func(), error) {
+		return func(i, j int) (float64, error) {
+			return math.Abs(results[i] - results[j]), nil
+		}, func() {}, nil
+	})
+	assert.NoError(t, err)
+	defer matrix.Close()
+	m := &matrixModel{items: items, matrix: matrix}
+
+	buckets, err := DistanceVarianceHistogram(m, DistanceVarianceOpts{
+		Bucketing:   FixedBuckets,
+		BucketSize:  2,
+		ExcludeSelf: true,
+	})
+	assert.NoError(t, err)
+	// pairs at distances 1, 1, 2, 2, 3 and 4
+	assert.Len(t, buckets, 3)
+	assert.Equal(t, DistanceBucket{Lower: 0, Upper: 2, Count: 2, Mean: 1}, buckets[0])
+	assert.Equal(t, 3, buckets[1].Count)
+	assert.InDelta(t, 7.0/3, buckets[1].Mean, 1e-9)
+	assert.InDelta(t, 2.0/9, buckets[1].Variance, 1e-9)
+	assert.Equal(t, 1, buckets[2].Count)
+
+	buckets, err = DistanceVarianceHistogram(m, DistanceVarianceOpts{
+		Bucketing: QuantileBuckets,
+		Buckets:   2,
+	})
+	assert.NoError(t, err)
+	// the 4 self pairs at distance 0 are kept
+	assert.Equal(t, 5, buckets[0].Count)
+	assert.Equal(t, 5, buckets[1].Count)
+	assert.Equal(t, float64(0), buckets[0].Lower)
+	assert.Equal(t, float64(4), buckets[1].Upper)
+
+	// items 10 apart overlap once they last 10 longer
+	buckets, err = DistanceVarianceHistogram(m, DistanceVarianceOpts{
+		Bucketing:          QuantileBuckets,
+		Buckets:            1,
+		ExcludeSelf:        true,
+		ExcludeOverlapping: true,
+		OverlapWindow:      10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, buckets[0].Count)
+
+	_, err = DistanceVarianceHistogram(m, DistanceVarianceOpts{})
+	assert.Error(t, err)
+}
+
+func TestLoadModel(t *testing.T) {
+	data := make([]record.Market, 40)
+	for i := range data {
+		data[i] = record.Market{
+			Timestamp: int64(i),
+			Open:      rand.Float64(),
+			High:      rand.Float64(),
+			Low:       rand.Float64(),
+			Close:     rand.Float64(),
+			Volume:    rand.Float64(),
+			VWAP:      rand.Float64(),
+		}
+	}
+	opts := splicer.SpliceOptions{
+		Period:            8,
+		ResultN:           2,
+		NormalisationType: record.ZScore,
+	}
+	dir := t.TempDir()
+
+	compression := NewCompressionModel(opts, SimpleEncoding, record.InterleaveCombine)
+	assert.NoError(t, compression.AddMarketData(data))
+	assert.NoError(t, compression.SaveToFile(filepath.Join(dir, "compression.model")))
+	loaded, err := LoadModel(filepath.Join(dir, "compression.model"))
+	assert.NoError(t, err)
+	assert.IsType(t, &CompressionModel{}, loaded)
+	assert.Equal(t, compression.Items, loaded.(*CompressionModel).Items)
+
+	euclidean := NewEuclideanModel(opts)
+	assert.NoError(t, euclidean.AddMarketData(data))
+	assert.NoError(t, euclidean.SaveToFile(filepath.Join(dir, "euclidean.model")))
+	loaded, err = LoadModel(filepath.Join(dir, "euclidean.model"))
+	assert.NoError(t, err)
+	assert.IsType(t, &VectorModel{}, loaded)
+	assert.Equal(t, Euclidean, loaded.(*VectorModel).Type)
+	assert.Equal(t, euclidean.Items, loaded.(*VectorModel).Items)
+}
+
+func TestNeighboursCount(t *testing.T) {
+	data := make([]record.Market, 20)
+	for i := range data {
+		data[i] = record.Market{
+			Timestamp: int64(i),
+			Open:      rand.Float64(),
+			High:      rand.Float64(),
+			Low:       rand.Float64(),
+			Close:     rand.Float64(),
+			Volume:    rand.Float64(),
+			VWAP:      rand.Float64(),
+		}
+	}
+	opts := splicer.SpliceOptions{
+		Period:            8,
+		ResultN:           2,
+		NormalisationType: record.ZScore,
+	}
+	for _, m := range []Model{
+		NewCompressionModel(opts, SimpleEncoding, record.InterleaveCombine),
+		NewEuclideanModel(opts),
+	} {
+		assert.NoError(t, m.AddMarketData(data))
+		observation := m.Item(0).Data
+		neighbours, err := m.Neighbours(observation, 3)
+		assert.NoError(t, err)
+		assert.Len(t, neighbours, 3)
+		_, err = m.Neighbours(observation, 0)
+		assert.Error(t, err)
+		_, err = m.Neighbours(observation, -1)
+		assert.Error(t, err)
+	}
+}
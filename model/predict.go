@@ -0,0 +1,219 @@
+package model
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+type PredictionStrategy string
+
+const (
+	DiscreteWNN  PredictionStrategy = "wnn"
+	ContinousWNN PredictionStrategy = "cwnn"
+	TopDog       PredictionStrategy = "top"
+)
+
+func ToPredictionStrategy(input string) (PredictionStrategy, error) {
+	switch input {
+	case string(DiscreteWNN):
+		return DiscreteWNN, nil
+	case string(ContinousWNN):
+		return ContinousWNN, nil
+	case string(TopDog):
+		return TopDog, nil
+	}
+	return DiscreteWNN, errors.New("invalid prediction strategy specified")
+}
+
+const (
+	Sell = -1
+	Hold = 0
+	Buy  = 1
+)
+
+type PredictionOpts struct {
+	Strategy PredictionStrategy
+	NearestN int
+	// Horizon is the number of bars ahead to predict, it must be one of the
+	// model's horizons, 0 predicts at the model's ResultN
+	Horizon int
+	// results above BuyThreshold are buys, results below SellThreshold
+	// are sells, and anything in between is a hold
+	BuyThreshold  float64
+	SellThreshold float64
+}
+
+// Classify returns whether a result is a Buy, Sell or Hold
+func (opts PredictionOpts) Classify(result float64) int {
+	if result > opts.BuyThreshold {
+		return Buy
+	} else if result < opts.SellThreshold {
+		return Sell
+	}
+	return Hold
+}
+
+// Votes is the share of the neighbours' weight given to each class
+type Votes struct {
+	Buy  float64 `json:"buy"`
+	Sell float64 `json:"sell"`
+	Hold float64 `json:"hold"`
+}
+
+type Prediction struct {
+	Strategy PredictionStrategy `json:"strategy"`
+	// Signal is one of Buy, Sell or Hold
+	Signal int `json:"signal"`
+	// Expected is the distance weighted mean of the neighbours' results
+	// and Dispersion is their weighted standard deviation
+	Expected   float64 `json:"expected"`
+	Dispersion float64 `json:"dispersion"`
+	// Confidence is in [0, 1], how it is derived depends on the strategy
+	Confidence float64 `json:"confidence"`
+	Votes      Votes   `json:"votes"`
+	Neighbours int     `json:"neighbours"`
+}
+
+// neighbourWeights weights neighbours by inverse distance, if any
+// neighbours are exact matches only they are given weight
+func neighbourWeights(distances []float64) []float64 {
+	weights := make([]float64, len(distances))
+	exactMatch := false
+	for _, d := range distances {
+		if d <= 0 {
+			exactMatch = true
+			break
+		}
+	}
+	for i, d := range distances {
+		if exactMatch {
+			if d <= 0 {
+				weights[i] = 1
+			}
+			continue
+		}
+		weights[i] = 1 / d
+	}
+	return weights
+}
+
+// normalCDF is the probability a standard normal variable is below x
+func normalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// PredictFromNeighbours turns an observation's neighbours, ordered by
+// ascending distance, into a prediction using the strategy in opts
+func PredictFromNeighbours(neighbours []*Neighbour, spliceOpts splicer.SpliceOptions, opts PredictionOpts) (*Prediction, error) {
+	horizonIndex, err := spliceOpts.HorizonIndex(opts.Horizon)
+	if err != nil {
+		return nil, err
+	}
+	distances := make([]float64, len(neighbours))
+	results := make([]float64, len(neighbours))
+	for i, n := range neighbours {
+		distances[i] = n.Distance
+		results[i] = n.Item.ResultAt(horizonIndex)
+	}
+	return PredictFromResults(distances, results, opts)
+}
+
+// PredictFromResults predicts from the distances and results of an
+// observation's neighbours, ordered by ascending distance
+func PredictFromResults(distances, results []float64, opts PredictionOpts) (*Prediction, error) {
+	if len(distances) == 0 {
+		return nil, errors.New("cannot predict without any neighbours")
+	}
+	prediction := &Prediction{
+		Strategy:   opts.Strategy,
+		Neighbours: len(distances),
+	}
+
+	weights := neighbourWeights(distances)
+	totalWeight := float64(0)
+	for i, result := range results {
+		w := weights[i]
+		totalWeight += w
+		prediction.Expected += w * result
+		switch opts.Classify(result) {
+		case Buy:
+			prediction.Votes.Buy += w
+		case Sell:
+			prediction.Votes.Sell += w
+		default:
+			prediction.Votes.Hold += w
+		}
+	}
+	prediction.Expected /= totalWeight
+	prediction.Votes.Buy /= totalWeight
+	prediction.Votes.Sell /= totalWeight
+	prediction.Votes.Hold /= totalWeight
+	for i, result := range results {
+		prediction.Dispersion += weights[i] * math.Pow(result-prediction.Expected, 2)
+	}
+	prediction.Dispersion = math.Sqrt(prediction.Dispersion / totalWeight)
+
+	switch opts.Strategy {
+	case DiscreteWNN:
+		// the class with the most weight wins, ties are a hold
+		votes := prediction.Votes
+		if votes.Buy > votes.Sell && votes.Buy > votes.Hold {
+			prediction.Signal = Buy
+			prediction.Confidence = votes.Buy
+		} else if votes.Sell > votes.Buy && votes.Sell > votes.Hold {
+			prediction.Signal = Sell
+			prediction.Confidence = votes.Sell
+		} else {
+			prediction.Signal = Hold
+			prediction.Confidence = votes.Hold
+		}
+	case ContinousWNN:
+		// confidence is the chance the result lands in the predicted class,
+		// assuming results are normally distributed around the expectation
+		prediction.Signal = opts.Classify(prediction.Expected)
+		if prediction.Dispersion == 0 {
+			prediction.Confidence = 1
+			break
+		}
+		aboveBuy := 1 - normalCDF((opts.BuyThreshold-prediction.Expected)/prediction.Dispersion)
+		belowSell := normalCDF((opts.SellThreshold - prediction.Expected) / prediction.Dispersion)
+		switch prediction.Signal {
+		case Buy:
+			prediction.Confidence = aboveBuy
+		case Sell:
+			prediction.Confidence = belowSell
+		default:
+			prediction.Confidence = 1 - aboveBuy - belowSell
+		}
+	case TopDog:
+		// confidence is how much closer the nearest neighbour is than the
+		// furthest one that was considered
+		prediction.Signal = opts.Classify(results[0])
+		prediction.Expected = results[0]
+		furthest := distances[len(distances)-1]
+		if furthest > 0 {
+			prediction.Confidence = 1 - distances[0]/furthest
+		} else {
+			prediction.Confidence = 1
+		}
+		if len(distances) == 1 {
+			prediction.Confidence = 1
+		}
+	default:
+		return nil, errors.New("invalid prediction strategy specified")
+	}
+
+	return prediction, nil
+}
+
+// predictFromModel finds the observation's neighbours and predicts from them
+func predictFromModel(m Model, observation record.Model, opts PredictionOpts) (*Prediction, error) {
+	neighbours, err := m.Neighbours(observation, opts.NearestN)
+	if err != nil {
+		return nil, err
+	}
+	return PredictFromNeighbours(neighbours, m.Options(), opts)
+}
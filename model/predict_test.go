@@ -0,0 +1,63 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+func TestPredictFromNeighbours(t *testing.T) {
+	neighbours := []*Neighbour{
+		{Distance: 0.5, Item: Item{Result: 2}},
+		{Distance: 1, Item: Item{Result: -2}},
+		{Distance: 1, Item: Item{Result: -1.5}},
+		{Distance: 2, Item: Item{Result: 0}},
+	}
+	spliceOpts := splicer.SpliceOptions{ResultN: 1}
+	opts := PredictionOpts{
+		NearestN:      4,
+		BuyThreshold:  1,
+		SellThreshold: -1,
+	}
+
+	t.Run("discrete wnn votes by inverse distance", func(t *testing.T) {
+		opts.Strategy = DiscreteWNN
+		prediction, err := PredictFromNeighbours(neighbours, spliceOpts, opts)
+		assert.NoError(t, err)
+		// weights are 2, 1, 1 and 0.5 out of 4.5, buy and sell tie
+		assert.Equal(t, Hold, prediction.Signal)
+		assert.InDelta(t, 2/4.5, prediction.Votes.Buy, 1e-9)
+		assert.InDelta(t, 2/4.5, prediction.Votes.Sell, 1e-9)
+		assert.InDelta(t, 0.5/4.5, prediction.Votes.Hold, 1e-9)
+	})
+
+	t.Run("continuous wnn uses the weighted expectation", func(t *testing.T) {
+		opts.Strategy = ContinousWNN
+		prediction, err := PredictFromNeighbours(neighbours, spliceOpts, opts)
+		assert.NoError(t, err)
+		assert.InDelta(t, (4-2-1.5)/4.5, prediction.Expected, 1e-9)
+		assert.Equal(t, Hold, prediction.Signal)
+		assert.True(t, prediction.Dispersion > 0)
+		assert.True(t, prediction.Confidence > 0 && prediction.Confidence < 1)
+	})
+
+	t.Run("top dog follows the nearest neighbour", func(t *testing.T) {
+		opts.Strategy = TopDog
+		prediction, err := PredictFromNeighbours(neighbours, spliceOpts, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, Buy, prediction.Signal)
+		assert.Equal(t, float64(2), prediction.Expected)
+		assert.InDelta(t, 0.75, prediction.Confidence, 1e-9)
+	})
+
+	t.Run("exact matches outweigh everything else", func(t *testing.T) {
+		opts.Strategy = DiscreteWNN
+		exact := append([]*Neighbour{{Distance: 0, Item: Item{Result: 0}}}, neighbours...)
+		prediction, err := PredictFromNeighbours(exact, spliceOpts, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, Hold, prediction.Signal)
+		assert.Equal(t, float64(1), prediction.Confidence)
+	})
+}
@@ -0,0 +1,198 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+	"github.com/hubertkaluzny/silly-trader/splicer"
+)
+
+// SeriesDistanceFunc returns the distance between two equal length series
+type SeriesDistanceFunc func(x1s, x2s []float64) (float64, error)
+
+// VectorModel holds splices as plain numeric series and compares them with
+// one of the vector metrics, rather than by compressing them
+type VectorModel struct {
+	Type          ModelType             `json:"model_type"`
+	SpliceOptions splicer.SpliceOptions `json:"splice_options"`
+	DTWWindow     int                   `json:"dtw_window"`
+	Items         []Item                `json:"items"`
+	DistanceCache
+	BuildOptions
+}
+
+var _ Model = (*VectorModel)(nil)
+
+func newVectorModel(modelType ModelType, spliceOpts splicer.SpliceOptions) *VectorModel {
+	return &VectorModel{
+		Type:          modelType,
+		SpliceOptions: spliceOpts,
+	}
+}
+
+func LoadVectorModelFromFile(file string) (*VectorModel, error) {
+	data, err := readModelFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return decodeVectorModel(file, data)
+}
+
+// decodeVectorModel decodes the contents of the model saved at file
+func decodeVectorModel(file string, data []byte) (*VectorModel, error) {
+	var model VectorModel
+	err := json.Unmarshal(data, &model)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := model.seriesDistance(); err != nil {
+		return nil, err
+	}
+	model.setModelPath(file)
+	return &model, nil
+}
+
+func (m *VectorModel) seriesDistance() (SeriesDistanceFunc, error) {
+	switch m.Type {
+	case Cosine:
+		return cosineDistanceBetween, nil
+	case Euclidean:
+		return euclideanDistanceBetween, nil
+	case Correlation:
+		return correlationDistanceBetween, nil
+	case DTW:
+		window := m.DTWWindow
+		return func(x1s, x2s []float64) (float64, error) {
+			return dtwDistanceBetween(x1s, x2s, window)
+		}, nil
+	}
+	return nil, errors.New("model type is not a vector model")
+}
+
+// VectorDistanceBetween averages the distance between each of the
+// ohlcv + vwap series of two items
+func VectorDistanceBetween(x1, x2 record.Model, distance SeriesDistanceFunc) (float64, error) {
+	pairs := [][2][]float64{
+		{x1.Opens, x2.Opens},
+		{x1.Highs, x2.Highs},
+		{x1.Lows, x2.Lows},
+		{x1.Closes, x2.Closes},
+		{x1.Volumes, x2.Volumes},
+		{x1.VWAPs, x2.VWAPs},
+	}
+	sum := float64(0)
+	for _, pair := range pairs {
+		d, err := distance(pair[0], pair[1])
+		if err != nil {
+			return math.MaxFloat64, err
+		}
+		sum += d
+	}
+	return sum / float64(len(pairs)), nil
+}
+
+func (m *VectorModel) AddMarketData(data []record.Market) error {
+	var batch []Item
+	worker := func() (func(i int, s splicer.Splice) error, func(), error) {
+		convert := func(i int, s splicer.Splice) error {
+			batch[i] = newItem(s)
+			return nil
+		}
+		return convert, func() {}, nil
+	}
+	startBatch := func(size int) {
+		batch = make([]Item, size)
+	}
+	endBatch := func() {
+		m.Items = append(m.Items, batch...)
+	}
+	return streamSplices(data, m.SpliceOptions, m.BuildOptions, worker, startBatch, endBatch)
+}
+
+func (m *VectorModel) Neighbours(observation record.Model, nearestN int) ([]*Neighbour, error) {
+	if nearestN < 1 {
+		return nil, errors.New("number of neighbours must be positive")
+	}
+	seriesDistance, err := m.seriesDistance()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Neighbour, nearestN)
+	for index, item := range m.Items {
+		distance, err := VectorDistanceBetween(item.Data, observation, seriesDistance)
+		if err != nil {
+			return nil, err
+		}
+		insertNeighbour(results, &Neighbour{
+			Distance: distance,
+			Index:    index,
+			Item:     item,
+		})
+	}
+	return trimNeighbours(results), nil
+}
+
+func (m *VectorModel) Predict(observation record.Model, opts PredictionOpts) (*Prediction, error) {
+	return predictFromModel(m, observation, opts)
+}
+
+// SizeResultBuckets groups results by the rounded magnitude of each
+// item's closes, the closest analogue to a compressed size
+func (m *VectorModel) SizeResultBuckets() map[int][]float64 {
+	buckets := make(map[int][]float64)
+	for _, item := range m.Items {
+		var sum float64
+		for _, c := range item.Data.Closes {
+			sum += c * c
+		}
+		bucket := int(math.Round(math.Sqrt(sum)))
+		buckets[bucket] = append(buckets[bucket], item.Result)
+	}
+	return buckets
+}
+
+func (m *VectorModel) DistanceMap() (*DistanceMatrix, error) {
+	seriesDistance, err := m.seriesDistance()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]uint64, len(m.Items))
+	for i, item := range m.Items {
+		keys[i] = ItemKey(item.Data)
+	}
+
+	worker := func() (DistanceFunc, func(), error) {
+		distance := func(i, j int) (float64, error) {
+			return VectorDistanceBetween(m.Items[i].Data, m.Items[j].Data, seriesDistance)
+		}
+		return distance, func() {}, nil
+	}
+
+	return m.distanceMap(keys, worker)
+}
+
+func (m *VectorModel) Options() splicer.SpliceOptions {
+	return m.SpliceOptions
+}
+
+func (m *VectorModel) Len() int {
+	return len(m.Items)
+}
+
+func (m *VectorModel) Item(i int) Item {
+	return m.Items[i]
+}
+
+func (m *VectorModel) ItemResult(i int) float64 {
+	return m.Items[i].Result
+}
+
+func (m *VectorModel) SaveToFile(file string) error {
+	err := m.saveDistanceMap(file)
+	if err != nil {
+		return err
+	}
+	return saveModelFile(file, m)
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationDistanceBetween(t *testing.T) {
+	distance, err := correlationDistanceBetween([]float64{1, 2, 3}, []float64{10, 20, 30})
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, distance, 1e-9)
+
+	distance, err = correlationDistanceBetween([]float64{1, 2, 3}, []float64{3, 2, 1})
+	assert.NoError(t, err)
+	assert.InDelta(t, 2, distance, 1e-9)
+
+	distance, err = correlationDistanceBetween([]float64{1, 1, 1}, []float64{3, 2, 1})
+	assert.NoError(t, err)
+	assert.InDelta(t, 1, distance, 1e-9)
+}
+
+func TestDTWDistanceBetween(t *testing.T) {
+	x1s := []float64{0, 0, 1, 2, 1, 0, 0}
+	x2s := []float64{0, 1, 2, 1, 0, 0, 0}
+
+	t.Run("zero window is euclidean", func(t *testing.T) {
+		dtw, err := dtwDistanceBetween(x1s, x2s, 0)
+		assert.NoError(t, err)
+		euclidean, err := euclideanDistanceBetween(x1s, x2s)
+		assert.NoError(t, err)
+		assert.InDelta(t, euclidean, dtw, 1e-9)
+	})
+
+	t.Run("window absorbs a shift", func(t *testing.T) {
+		dtw, err := dtwDistanceBetween(x1s, x2s, 1)
+		assert.NoError(t, err)
+		assert.InDelta(t, 0, dtw, 1e-9)
+	})
+}
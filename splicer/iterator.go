@@ -0,0 +1,94 @@
+package splicer
+
+import (
+	"errors"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+// Iterator lazily splices market data, so only the splice currently being
+// looked at has to be held in memory
+type Iterator struct {
+	data     []record.Market
+	opts     SpliceOptions
+	horizons []int
+	// span is the splice's period plus the longest horizon
+	span int
+	next int
+	cur  Splice
+	err  error
+}
+
+func NewIterator(data []record.Market, opts SpliceOptions) (*Iterator, error) {
+	horizons := opts.AllHorizons()
+	// splices must be followed by enough data for the longest horizon
+	span := opts.Period + horizons[len(horizons)-1]
+	if opts.Period < 1 {
+		return nil, errors.New("period must be positive")
+	}
+	if len(data) < span {
+		return nil, ErrInsufficientData
+	}
+	return &Iterator{
+		data:     data,
+		opts:     opts,
+		horizons: horizons,
+		span:     span,
+	}, nil
+}
+
+// Len returns the total number of splices the iterator will produce
+func (it *Iterator) Len() int {
+	return (len(it.data)-it.span)/(1+it.opts.SkipN) + 1
+}
+
+// Next advances to the next splice, returning false when there are none
+// left or an error occurred
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.next+it.span > len(it.data) {
+		return false
+	}
+	period := it.opts.Period
+	curPeriodData := it.data[it.next:(it.next + it.span)]
+	it.next += 1 + it.opts.SkipN
+
+	// only the splice itself is normalised, so nothing from the
+	// result horizon leaks into the data being compared
+	spliceData := curPeriodData[0:period]
+	switch it.opts.NormalisationType {
+	case record.ZScore:
+		spliceData = record.NormaliseToZScore(spliceData)
+	}
+
+	var result float64
+	results := make([]float64, len(it.horizons))
+	for h, horizon := range it.horizons {
+		res, err := Label(curPeriodData, period, horizon, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		results[h] = res
+		if horizon == it.opts.ResultN {
+			result = res
+		}
+	}
+
+	it.cur = Splice{
+		Data:       spliceData,
+		StartTime:  spliceData[0].Timestamp,
+		EndTime:    spliceData[period-1].Timestamp,
+		ResultTime: curPeriodData[it.span-1].Timestamp,
+		Result:     result,
+		Results:    results,
+	}
+	return true
+}
+
+func (it *Iterator) Item() Splice {
+	return it.cur
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,147 @@
+package splicer
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hubertkaluzny/silly-trader/record"
+)
+
+type LabelType string
+
+const (
+	// DiffLabel is the price difference between the close of the splice and
+	// the open at the end of the horizon, in units of the splice's close
+	// standard deviation when z-score normalised
+	DiffLabel LabelType = "diff"
+	// PercentLabel is the fractional return over the horizon
+	PercentLabel LabelType = "pct"
+	// LogLabel is the log return over the horizon
+	LogLabel LabelType = "log"
+	// SignLabel is 1 or -1 for returns beyond DeadZone either way, else 0
+	SignLabel LabelType = "sign"
+	// MFELabel is the best return reached at any point over the horizon
+	MFELabel LabelType = "mfe"
+	// MAELabel is the worst return reached at any point over the horizon
+	MAELabel LabelType = "mae"
+	// TripleBarrierLabel is 1 if TakeProfit is hit first, -1 if StopLoss is
+	// hit first, and 0 if neither is hit before the horizon
+	TripleBarrierLabel LabelType = "triple_barrier"
+)
+
+func ToLabelType(input string) (LabelType, error) {
+	switch input {
+	case string(DiffLabel):
+		return DiffLabel, nil
+	case string(PercentLabel):
+		return PercentLabel, nil
+	case string(LogLabel):
+		return LogLabel, nil
+	case string(SignLabel):
+		return SignLabel, nil
+	case string(MFELabel):
+		return MFELabel, nil
+	case string(MAELabel):
+		return MAELabel, nil
+	case string(TripleBarrierLabel):
+		return TripleBarrierLabel, nil
+	}
+	return DiffLabel, errors.New("invalid label type specified")
+}
+
+// Thresholds returns the results above which a splice is a buy and below
+// which it is a sell, for the label type in opts
+func Thresholds(opts SpliceOptions) (float64, float64) {
+	switch opts.LabelType {
+	case PercentLabel, LogLabel:
+		return opts.DeadZone, -opts.DeadZone
+	case SignLabel, TripleBarrierLabel:
+		return 0.5, -0.5
+	case MFELabel:
+		return opts.TakeProfit, math.Inf(-1)
+	case MAELabel:
+		return math.Inf(1), -opts.StopLoss
+	}
+	if opts.NormalisationType == record.ZScore {
+		return 1, -1
+	}
+	return 0, 0
+}
+
+// Label computes the result of a splice from raw market data, where the
+// first period records are the splice and the horizon records after it
+// are what followed
+func Label(data []record.Market, period, horizon int, opts SpliceOptions) (float64, error) {
+	if period < 1 || horizon < 1 || len(data) < period+horizon {
+		return 0, ErrInsufficientData
+	}
+	entry := data[period-1].Close
+	exit := data[period+horizon-1].Open
+	following := data[period : period+horizon]
+
+	switch opts.LabelType {
+	case "", DiffLabel:
+		diff := exit - entry
+		if opts.NormalisationType == record.ZScore {
+			std := closeStdDev(data[:period])
+			if std == 0 {
+				return 0, nil
+			}
+			diff /= std
+		}
+		return diff, nil
+	case PercentLabel:
+		return exit/entry - 1, nil
+	case LogLabel:
+		return math.Log(exit / entry), nil
+	case SignLabel:
+		ret := exit/entry - 1
+		if ret > opts.DeadZone {
+			return 1, nil
+		} else if ret < -opts.DeadZone {
+			return -1, nil
+		}
+		return 0, nil
+	case MFELabel:
+		best := math.Inf(-1)
+		for _, rec := range following {
+			best = math.Max(best, rec.High)
+		}
+		return best/entry - 1, nil
+	case MAELabel:
+		worst := math.Inf(1)
+		for _, rec := range following {
+			worst = math.Min(worst, rec.Low)
+		}
+		return worst/entry - 1, nil
+	case TripleBarrierLabel:
+		upper := entry * (1 + opts.TakeProfit)
+		lower := entry * (1 - opts.StopLoss)
+		for _, rec := range following {
+			// if both are touched within a bar we can't tell which came
+			// first, so assume the worst
+			if rec.Low <= lower {
+				return -1, nil
+			}
+			if rec.High >= upper {
+				return 1, nil
+			}
+		}
+		return 0, nil
+	}
+	return 0, errors.New("invalid label type specified")
+}
+
+func closeStdDev(data []record.Market) float64 {
+	N := float64(len(data))
+	mean := float64(0)
+	for _, rec := range data {
+		mean += rec.Close
+	}
+	mean /= N
+	variance := float64(0)
+	for _, rec := range data {
+		variance += math.Pow(rec.Close-mean, 2)
+	}
+	return math.Sqrt(variance / N)
+}
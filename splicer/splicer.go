@@ -2,55 +2,100 @@ package splicer
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/hubertkaluzny/silly-trader/record"
 )
 
+// ErrInsufficientData is returned when there is too little data to make a
+// single splice
+var ErrInsufficientData = errors.New("insufficient data length provided for provided params")
+
 type Splice struct {
-	Data              []record.Market          `json:"data"`
-	StartTime         int64                    `json:"start_time"`
-	EndTime           int64                    `json:"end_time"`
+	Data      []record.Market `json:"data"`
+	StartTime int64           `json:"start_time"`
+	EndTime   int64           `json:"end_time"`
+	// ResultTime is the time of the last record any of the results used
+	ResultTime        int64                    `json:"result_time"`
 	Result            float64                  `json:"result"`
+	Results           []float64                `json:"results"`
 	NormalisationType record.NormalisationType `json:"normalisation_type"`
 }
 
 type SpliceOptions struct {
-	Period            int                      `json:"period"`
-	ResultN           int                      `json:"result_n"`
+	Period  int `json:"period"`
+	ResultN int `json:"result_n"`
+	// Horizons are extra numbers of bars after the splice to record
+	// results for, Result is always taken at ResultN
+	Horizons          []int                    `json:"horizons"`
 	SkipN             int                      `json:"skip_n"`
 	NormalisationType record.NormalisationType `json:"normalisation_type"`
+	LabelType         LabelType                `json:"label_type"`
+	// DeadZone is the fractional return either side of zero that is
+	// considered flat by the sign label
+	DeadZone float64 `json:"dead_zone"`
+	// TakeProfit and StopLoss are the fractional returns that form the
+	// barriers of the triple barrier label
+	TakeProfit float64 `json:"take_profit"`
+	StopLoss   float64 `json:"stop_loss"`
 }
 
-func SpliceData(data []record.Market, opts SpliceOptions) ([]Splice, error) {
-	period := opts.Period
-	resultN := opts.ResultN
-	if len(data) < period+resultN {
-		return nil, errors.New("insufficient data length provided for provided params")
+// AllHorizons returns every horizon results are recorded for in ascending
+// order, including ResultN
+func (opts SpliceOptions) AllHorizons() []int {
+	horizons := []int{opts.ResultN}
+	for _, h := range opts.Horizons {
+		found := false
+		for _, existing := range horizons {
+			if existing == h {
+				found = true
+				break
+			}
+		}
+		if !found {
+			horizons = append(horizons, h)
+		}
 	}
+	sort.Ints(horizons)
+	return horizons
+}
 
-	var splices []Splice
-	for i := 0; i+period+resultN-1 < len(data); i += 1 + opts.SkipN {
-		curPeriodData := data[i:(i + period + resultN)]
-		switch opts.NormalisationType {
-		case record.ZScore:
-			curPeriodData = record.NormaliseToZScore(curPeriodData)
+// HorizonIndex returns where a horizon's result is in a splice's Results,
+// a horizon of 0 refers to ResultN
+func (opts SpliceOptions) HorizonIndex(horizon int) (int, error) {
+	if horizon == 0 {
+		horizon = opts.ResultN
+	}
+	for i, h := range opts.AllHorizons() {
+		if h == horizon {
+			return i, nil
 		}
-		spliceData := curPeriodData[0:period]
-
-		startTime := spliceData[0].Timestamp
-		endTime := spliceData[period-1].Timestamp
-
-		priceAtClose := spliceData[period-1].Close
-		priceAtResult := curPeriodData[period+resultN-1].Open
-		result := priceAtResult - priceAtClose
+	}
+	return -1, errors.New("horizon is not recorded by the splice options")
+}
 
-		splices = append(splices, Splice{
-			Data:      spliceData,
-			StartTime: startTime,
-			EndTime:   endTime,
-			Result:    result,
-		})
+func SpliceData(data []record.Market, opts SpliceOptions) ([]Splice, error) {
+	it, err := NewIterator(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	splices := make([]Splice, 0, it.Len())
+	for it.Next() {
+		splices = append(splices, it.Item())
 	}
+	return splices, it.Err()
+}
 
-	return splices, nil
+// Observation normalises the trailing period of data the same way each
+// splice's data is normalised, ready to be compared against a model
+func Observation(data []record.Market, opts SpliceOptions) (record.Model, error) {
+	if len(data) < opts.Period {
+		return record.Model{}, ErrInsufficientData
+	}
+	window := data[len(data)-opts.Period:]
+	switch opts.NormalisationType {
+	case record.ZScore:
+		window = record.NormaliseToZScore(window)
+	}
+	return record.MarketToModel(window), nil
 }
@@ -1,6 +1,7 @@
 package splicer
 
 import (
+	"math"
 	"math/rand"
 	"testing"
 
@@ -115,3 +116,103 @@ func TestSpliceData(t *testing.T) {
 		})
 	})
 }
+
+func TestLabel(t *testing.T) {
+	// two bar splice closing at 100, followed by a three bar horizon
+	data := []record.Market{
+		{Open: 98, High: 99, Low: 97, Close: 98},
+		{Open: 98, High: 101, Low: 97, Close: 100},
+		{Open: 100, High: 104, Low: 99, Close: 103},
+		{Open: 103, High: 103, Low: 95, Close: 96},
+		{Open: 102, High: 102, Low: 100, Close: 101},
+	}
+	runTest := func(t *testing.T, opts SpliceOptions, expected float64) {
+		result, err := Label(data, 2, 3, opts)
+		assert.NoError(t, err)
+		assert.InDelta(t, expected, result, 1e-9)
+	}
+
+	t.Run("diff", func(t *testing.T) {
+		runTest(t, SpliceOptions{LabelType: DiffLabel, NormalisationType: record.None}, 2)
+		// closes of the splice have a standard deviation of 1
+		runTest(t, SpliceOptions{LabelType: DiffLabel, NormalisationType: record.ZScore}, 2)
+	})
+
+	t.Run("percent and log returns", func(t *testing.T) {
+		runTest(t, SpliceOptions{LabelType: PercentLabel}, 0.02)
+		runTest(t, SpliceOptions{LabelType: LogLabel}, math.Log(1.02))
+	})
+
+	t.Run("sign with dead zone", func(t *testing.T) {
+		runTest(t, SpliceOptions{LabelType: SignLabel, DeadZone: 0.01}, 1)
+		runTest(t, SpliceOptions{LabelType: SignLabel, DeadZone: 0.05}, 0)
+	})
+
+	t.Run("excursions", func(t *testing.T) {
+		runTest(t, SpliceOptions{LabelType: MFELabel}, 0.04)
+		runTest(t, SpliceOptions{LabelType: MAELabel}, -0.05)
+	})
+
+	t.Run("triple barrier", func(t *testing.T) {
+		runTest(t, SpliceOptions{LabelType: TripleBarrierLabel, TakeProfit: 0.03, StopLoss: 0.03}, 1)
+		runTest(t, SpliceOptions{LabelType: TripleBarrierLabel, TakeProfit: 0.05, StopLoss: 0.03}, -1)
+		runTest(t, SpliceOptions{LabelType: TripleBarrierLabel, TakeProfit: 0.1, StopLoss: 0.1}, 0)
+	})
+}
+
+func TestSpliceDataHorizons(t *testing.T) {
+	testData := randomMarketData(40)
+	opts := SpliceOptions{
+		Period:            5,
+		ResultN:           2,
+		Horizons:          []int{6, 1, 2},
+		NormalisationType: record.None,
+	}
+	assert.Equal(t, []int{1, 2, 6}, opts.AllHorizons())
+
+	splices, err := SpliceData(testData, opts)
+	assert.NoError(t, err)
+	// every splice needs data for the longest horizon
+	assert.Equal(t, 40-5-6+1, len(splices))
+
+	for i, splice := range splices {
+		assert.Equal(t, 3, len(splice.Results))
+		assert.Equal(t, splice.Result, splice.Results[1])
+		entry := testData[i+4].Close
+		assert.InDelta(t, testData[i+5].Open-entry, splice.Results[0], 1e-9)
+		assert.InDelta(t, testData[i+10].Open-entry, splice.Results[2], 1e-9)
+	}
+
+	index, err := opts.HorizonIndex(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, index)
+	_, err = opts.HorizonIndex(3)
+	assert.Error(t, err)
+}
+
+func TestIterator(t *testing.T) {
+	testData := randomMarketData(731)
+	opts := SpliceOptions{
+		Period:            18,
+		ResultN:           5,
+		SkipN:             7,
+		NormalisationType: record.ZScore,
+	}
+	it, err := NewIterator(testData, opts)
+	assert.NoError(t, err)
+
+	splices, err := SpliceData(testData, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, len(splices), it.Len())
+
+	count := 0
+	for it.Next() {
+		assert.Equal(t, splices[count], it.Item())
+		count++
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, len(splices), count)
+
+	_, err = NewIterator(testData[:20], opts)
+	assert.ErrorIs(t, err, ErrInsufficientData)
+}